@@ -0,0 +1,56 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Branches lists the branches of a repository matching filter
+func (c *Client) Branches(project string, repo string, filter string) ([]Branch, error) {
+	var all []Branch
+	query := url.Values{}
+	if filter != "" {
+		query.Set("filterText", filter)
+	}
+	err := c.getPaged(fmt.Sprintf("%s/branches", c.repoUrl(project, repo)), query, func(values json.RawMessage) error {
+		var branches []Branch
+		err := json.Unmarshal(values, &branches)
+		all = append(all, branches...)
+		return err
+	})
+	return all, err
+}
+
+// Branch returns the branch with exactly the given name, or nil when it doesn't exist
+func (c *Client) Branch(project string, repo string, name string) (*Branch, error) {
+	branches, err := c.Branches(project, repo, name)
+	if err != nil {
+		return nil, err
+	}
+	ref := fmt.Sprintf("refs/heads/%s", name)
+	for _, b := range branches {
+		if b.ID == ref {
+			return &b, nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *Client) CreateBranch(project string, repo string, branch CreateBranch) (*Branch, error) {
+	var created Branch
+	err := c.Do("POST", fmt.Sprintf("%s/branches", c.repoUrl(project, repo)), branch, &created)
+	return &created, err
+}
+
+func (c *Client) DefaultBranch(project string, repo string) (*Branch, error) {
+	var branch Branch
+	err := c.Do("GET", fmt.Sprintf("%s/branches/default", c.repoUrl(project, repo)), nil, &branch)
+	return &branch, err
+}
+
+func (c *Client) Commit(project string, repo string, id string) (*Commit, error) {
+	var commit Commit
+	err := c.Do("GET", fmt.Sprintf("%s/commits/%s", c.repoUrl(project, repo), url.PathEscape(id)), nil, &commit)
+	return &commit, err
+}
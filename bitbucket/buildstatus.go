@@ -0,0 +1,24 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// SetBuildStatus reports a build result against a commit
+func (c *Client) SetBuildStatus(commit string, status BuildStatus) error {
+	return c.Do("POST", fmt.Sprintf("%s/commits/%s", c.restUrl("build-status/1.0"), url.PathEscape(commit)), status, nil)
+}
+
+// BuildStatuses lists the build results reported against a commit
+func (c *Client) BuildStatuses(commit string) ([]BuildStatus, error) {
+	var all []BuildStatus
+	err := c.getPaged(fmt.Sprintf("%s/commits/%s", c.restUrl("build-status/1.0"), url.PathEscape(commit)), nil, func(values json.RawMessage) error {
+		var statuses []BuildStatus
+		err := json.Unmarshal(values, &statuses)
+		all = append(all, statuses...)
+		return err
+	})
+	return all, err
+}
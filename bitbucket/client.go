@@ -0,0 +1,81 @@
+// Package bitbucket is a small typed client for the Bitbucket Server / Data
+// Center REST API.
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/internal/rest"
+)
+
+// DefaultHTTPClient is shared by every Client that doesn't bring its own
+var DefaultHTTPClient = rest.DefaultHTTPClient
+
+// Authenticator sets the credentials on an outgoing request. go-git's http
+// BasicAuth and TokenAuth satisfy it, so the git and REST auth can be shared.
+type Authenticator = rest.Authenticator
+
+// Client talks to a single Bitbucket Server instance. ApiUrl is the core REST
+// API, e.g. https://bitbucket.example.com/rest/api/1.0. The other REST APIs
+// (build-status, insights) are resolved next to it.
+type Client struct {
+	ApiUrl     string
+	Auth       Authenticator
+	HTTPClient *http.Client
+}
+
+func NewClient(apiUrl string, auth Authenticator) *Client {
+	return &Client{ApiUrl: strings.TrimSuffix(apiUrl, "/"), Auth: auth, HTTPClient: DefaultHTTPClient}
+}
+
+// restUrl returns the url of another REST API on the same server, e.g.
+// restUrl("build-status/1.0") for the build status API
+func (c *Client) restUrl(api string) string {
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(c.ApiUrl, "/api/1.0"), api)
+}
+
+func (c *Client) repoUrl(project string, repo string) string {
+	return fmt.Sprintf("%s/projects/%s/repos/%s", c.ApiUrl, url.PathEscape(project), url.PathEscape(repo))
+}
+
+// Do sends a request with a JSON body built from in (when not nil) and decodes
+// a successful response into out (when not nil). A response outside the 2xx
+// range is returned as an *Error.
+func (c *Client) Do(method string, url string, in interface{}, out interface{}) error {
+	return rest.Client{
+		Service:    "bitbucket",
+		Auth:       c.Auth,
+		HTTPClient: c.HTTPClient,
+		Header:     http.Header{"X-Atlassian-Token": {"no-check"}},
+		Messages:   errorMessages,
+	}.Do(method, url, in, out)
+}
+
+// getPaged walks every page of a paged collection, handing the raw values of
+// each page to collect
+func (c *Client) getPaged(u string, query url.Values, collect func(values json.RawMessage) error) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	start := 0
+	for {
+		query.Set("start", fmt.Sprintf("%d", start))
+		var page Page
+		err := c.Do("GET", fmt.Sprintf("%s?%s", u, query.Encode()), nil, &page)
+		if err != nil {
+			return err
+		}
+		err = collect(page.Values)
+		if err != nil {
+			return err
+		}
+		if page.IsLastPage || page.NextPageStart <= start {
+			return nil
+		}
+		start = page.NextPageStart
+	}
+}
@@ -0,0 +1,48 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/internal/rest"
+)
+
+// ErrorMessage is one entry of the errors[] body Bitbucket returns on failure
+type ErrorMessage struct {
+	Context       string `json:"context"`
+	Message       string `json:"message"`
+	ExceptionName string `json:"exceptionName"`
+}
+
+// Error is returned for every response outside the 2xx range
+type Error = rest.Error
+
+// errorMessages reads the errors[] of a failed request's body
+func errorMessages(body []byte) []string {
+	var failure struct {
+		Errors []ErrorMessage `json:"errors"`
+	}
+	if json.Unmarshal(body, &failure) != nil {
+		return nil
+	}
+	var messages []string
+	for _, m := range failure.Errors {
+		if m.ExceptionName != "" {
+			messages = append(messages, fmt.Sprintf("%s (%s)", m.Message, m.ExceptionName))
+		} else {
+			messages = append(messages, m.Message)
+		}
+	}
+	return messages
+}
+
+// IsNotFound reports whether err is a Bitbucket 404
+func IsNotFound(err error) bool {
+	return rest.IsStatus(err, http.StatusNotFound)
+}
+
+// IsConflict reports whether err is a Bitbucket 409, e.g. a stale pull request version
+func IsConflict(err error) bool {
+	return rest.IsStatus(err, http.StatusConflict)
+}
@@ -0,0 +1,173 @@
+package fake
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// Signature is the author of every commit written by the fake
+var Signature = object.Signature{Name: "auto-release-pr", Email: "auto-release-pr@example.com"}
+
+// SeedRepository creates a bare repository at path holding files in a single
+// commit on branch, which is also made the default branch
+func SeedRepository(path string, branch string, files map[string]string) error {
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return err
+	}
+	storage := filesystem.NewStorage(osfs.New(path), cache.NewObjectLRUDefault())
+	fs := memfs.New()
+	r, err := git.Init(storage, fs)
+	if err != nil {
+		return err
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Core.IsBare = true
+	err = r.SetConfig(cfg)
+	if err != nil {
+		return err
+	}
+	head := plumbing.NewBranchReferenceName(branch)
+	err = r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, head))
+	if err != nil {
+		return err
+	}
+
+	wt, err := r.Worktree()
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err = util.WriteFile(fs, name, []byte(files[name]), 0644)
+		if err != nil {
+			return err
+		}
+		_, err = wt.Add(name)
+		if err != nil {
+			return err
+		}
+	}
+	author := Signature
+	author.When = time.Now()
+	_, err = wt.Commit("Initial commit", &git.CommitOptions{Author: &author})
+	return err
+}
+
+// ReadFile returns the content of name at the tip of branch in the bare
+// repository at path
+func ReadFile(path string, branch string, name string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", err
+	}
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return "", err
+	}
+	commit, err := r.CommitObject(ref.Hash())
+	if err != nil {
+		return "", err
+	}
+	file, err := commit.File(filepath.ToSlash(name))
+	if err != nil {
+		return "", err
+	}
+	return file.Contents()
+}
+
+// Head returns the commit hash at the tip of branch in the bare repository at
+// path
+func Head(path string, branch string) (string, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return "", err
+	}
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return "", err
+	}
+	return ref.Hash().String(), nil
+}
+
+// SetBranch points branch at the tip of from, e.g. to fast forward main onto
+// a merged release branch
+func SetBranch(path string, branch string, from string) error {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return err
+	}
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(from), true)
+	if err != nil {
+		return err
+	}
+	return r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), ref.Hash()))
+}
+
+// CommitFiles writes files on top of branch in the bare repository at path
+// and returns the new commit hash. A missing branch is created from the
+// default branch, which is otherwise left alone.
+func CommitFiles(path string, branch string, message string, files map[string]string) (string, error) {
+	storage := filesystem.NewStorage(osfs.New(path), cache.NewObjectLRUDefault())
+	fs := memfs.New()
+	r, err := git.Open(storage, fs)
+	if err != nil {
+		return "", err
+	}
+	head, err := r.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return "", err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return "", err
+	}
+	name := plumbing.NewBranchReferenceName(branch)
+	_, err = r.Reference(name, false)
+	err = wt.Checkout(&git.CheckoutOptions{Branch: name, Create: err != nil, Force: true})
+	if err != nil {
+		return "", err
+	}
+	defer r.Storer.SetReference(head)
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err = util.WriteFile(fs, name, []byte(files[name]), 0644)
+		if err != nil {
+			return "", err
+		}
+		_, err = wt.Add(name)
+		if err != nil {
+			return "", err
+		}
+	}
+	author := Signature
+	author.When = time.Now()
+	hash, err := wt.Commit(message, &git.CommitOptions{Author: &author})
+	if err != nil {
+		return "", err
+	}
+	return hash.String(), nil
+}
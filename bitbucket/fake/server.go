@@ -0,0 +1,620 @@
+// Package fake is an in-process stand-in for Bitbucket Server backed by bare
+// git repositories on disk. It implements the parts of the REST API the
+// release flow uses so it can run end to end without a network.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/bitbucket"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+const pageLimit = 25
+
+// Server serves <project>/<repo>.git repositories found under Root
+type Server struct {
+	*httptest.Server
+	Root string
+
+	mu           sync.Mutex
+	nextID       int
+	pullRequests map[string][]*bitbucket.PullRequest
+	comments     map[string][]bitbucket.Comment
+	groups       map[string][]string
+	builds       map[string][]bitbucket.BuildStatus
+	reports      map[string]bitbucket.Report
+	annotations  map[string][]bitbucket.Annotation
+
+	// RequiredApprovals is the number of approvals the merge check asks for
+	RequiredApprovals int
+
+	// Token, when set, is the only access token accepted. Requests without
+	// credentials are always rejected.
+	Token string
+}
+
+func NewServer(root string) *Server {
+	s := &Server{
+		Root:         root,
+		pullRequests: map[string][]*bitbucket.PullRequest{},
+		comments:     map[string][]bitbucket.Comment{},
+		groups:       map[string][]string{},
+		builds:       map[string][]bitbucket.BuildStatus{},
+		reports:      map[string]bitbucket.Report{},
+		annotations:  map[string][]bitbucket.Annotation{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/api/1.0/projects/", s.handleRepo)
+	mux.HandleFunc("/rest/api/1.0/admin/groups/more-members", s.groupMembers)
+	mux.HandleFunc("/rest/build-status/1.0/commits/", s.handleBuildStatus)
+	mux.HandleFunc("/rest/insights/1.0/projects/", s.handleReport)
+	s.Server = httptest.NewServer(s.authenticate(mux))
+	return s
+}
+
+// authenticate rejects anonymous requests and, with Token set, any other
+// credentials than a Bearer Token
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		s.mu.Lock()
+		token := s.Token
+		s.mu.Unlock()
+		switch {
+		case header == "":
+			writeError(w, http.StatusUnauthorized, "authentication required")
+		case token != "" && header != "Bearer "+token:
+			writeError(w, http.StatusUnauthorized, "invalid access token")
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// ApiUrl is the core REST API url to hand to the client under test
+func (s *Server) ApiUrl() string {
+	return s.URL + "/rest/api/1.0"
+}
+
+// CloneUrl is a clone url template pointing at the repositories under Root
+func (s *Server) CloneUrl() string {
+	return "file://" + filepath.ToSlash(s.Root) + "/{project}/{repo}.git"
+}
+
+// RepoPath is where the bare repository for project/repo lives
+func (s *Server) RepoPath(project string, repo string) string {
+	return filepath.Join(s.Root, project, repo+".git")
+}
+
+// PullRequests returns a copy of every pull request opened against project/repo
+func (s *Server) PullRequests(project string, repo string) []bitbucket.PullRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var prs []bitbucket.PullRequest
+	for _, pr := range s.pullRequests[project+"/"+repo] {
+		prs = append(prs, *pr)
+	}
+	return prs
+}
+
+// Comments returns the comments left on a pull request
+func (s *Server) Comments(project string, repo string, id int) []bitbucket.Comment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]bitbucket.Comment(nil), s.comments[fmt.Sprintf("%s/%s/%d", project, repo, id)]...)
+}
+
+// Approve records user approving pull request id
+func (s *Server) Approve(project string, repo string, id int, user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.review(project, repo, id, user, bitbucket.ReviewerApproved)
+}
+
+// NeedsWork records user asking for changes on pull request id
+func (s *Server) NeedsWork(project string, repo string, id int, user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.review(project, repo, id, user, bitbucket.ReviewerNeedsWork)
+}
+
+func (s *Server) review(project string, repo string, id int, user string, status string) {
+	pr := s.findPullRequest(project+"/"+repo, id)
+	if pr == nil {
+		return
+	}
+	for i := range pr.Reviewers {
+		if pr.Reviewers[i].User.Name == user {
+			pr.Reviewers[i].Status = status
+			pr.Reviewers[i].Approved = status == bitbucket.ReviewerApproved
+			return
+		}
+	}
+	pr.Reviewers = append(pr.Reviewers, bitbucket.Participant{
+		User:     bitbucket.User{Name: user},
+		Role:     "REVIEWER",
+		Status:   status,
+		Approved: status == bitbucket.ReviewerApproved,
+	})
+}
+
+// BuildStatuses returns the build results reported against commit
+func (s *Server) BuildStatuses(commit string) []bitbucket.BuildStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]bitbucket.BuildStatus(nil), s.builds[commit]...)
+}
+
+// handleBuildStatus serves /rest/build-status/1.0/commits/{commit}, a status
+// posted again with the same key replaces the earlier one
+func (s *Server) handleBuildStatus(w http.ResponseWriter, r *http.Request) {
+	commit := strings.TrimPrefix(r.URL.Path, "/rest/build-status/1.0/commits/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case "GET":
+		var values []interface{}
+		for _, status := range s.builds[commit] {
+			values = append(values, status)
+		}
+		writePage(w, r, values)
+	case "POST":
+		var status bitbucket.BuildStatus
+		err := json.NewDecoder(r.Body).Decode(&status)
+		if err != nil || status.Key == "" || status.State == "" {
+			writeError(w, http.StatusBadRequest, "a build status needs a key and a state")
+			return
+		}
+		statuses := s.builds[commit][:0]
+		for _, existing := range s.builds[commit] {
+			if existing.Key != status.Key {
+				statuses = append(statuses, existing)
+			}
+		}
+		s.builds[commit] = append(statuses, status)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s is not supported", r.Method)
+	}
+}
+
+// Report returns the Code Insights report key on commit and its annotations,
+// nil if it was never created
+func (s *Server) Report(project string, repo string, commit string, key string) (*bitbucket.Report, []bitbucket.Annotation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := strings.Join([]string{project, repo, commit, key}, "/")
+	report, ok := s.reports[id]
+	if !ok {
+		return nil, nil
+	}
+	return &report, append([]bitbucket.Annotation(nil), s.annotations[id]...)
+}
+
+// handleReport serves
+// /rest/insights/1.0/projects/{project}/repos/{repo}/commits/{commit}/reports/{key}
+// and its annotations
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/rest/insights/1.0/projects/"), "/")
+	if len(parts) < 7 || parts[1] != "repos" || parts[3] != "commits" || parts[5] != "reports" {
+		writeError(w, http.StatusNotFound, "no such resource %s", r.URL.Path)
+		return
+	}
+	id := strings.Join([]string{parts[0], parts[2], parts[4], parts[6]}, "/")
+	rest := parts[7:]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case len(rest) == 0 && r.Method == "GET":
+		report, ok := s.reports[id]
+		if !ok {
+			writeError(w, http.StatusNotFound, "report %s does not exist", id)
+			return
+		}
+		writeJSON(w, http.StatusOK, report)
+	case len(rest) == 0 && r.Method == "PUT":
+		var report bitbucket.Report
+		err := json.NewDecoder(r.Body).Decode(&report)
+		if err != nil || report.Title == "" {
+			writeError(w, http.StatusBadRequest, "a report needs a title")
+			return
+		}
+		if len(report.Data) > 6 {
+			writeError(w, http.StatusBadRequest, "a report has at most 6 data values")
+			return
+		}
+		s.reports[id] = report
+		writeJSON(w, http.StatusOK, report)
+	case len(rest) == 1 && rest[0] == "annotations" && r.Method == "DELETE":
+		delete(s.annotations, id)
+		w.WriteHeader(http.StatusNoContent)
+	case len(rest) == 1 && rest[0] == "annotations" && r.Method == "POST":
+		if _, ok := s.reports[id]; !ok {
+			writeError(w, http.StatusNotFound, "report %s does not exist", id)
+			return
+		}
+		var body struct {
+			Annotations []bitbucket.Annotation `json:"annotations"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		s.annotations[id] = append(s.annotations[id], body.Annotations...)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s %s is not supported", r.Method, r.URL.Path)
+	}
+}
+
+// SetGroup defines a user group with the given member user names
+func (s *Server) SetGroup(name string, members ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.groups[name] = members
+}
+
+func (s *Server) groupMembers(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := r.URL.Query().Get("context")
+	members, ok := s.groups[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "group %s does not exist", name)
+		return
+	}
+	var values []interface{}
+	for _, member := range members {
+		values = append(values, bitbucket.User{Name: member, Slug: member})
+	}
+	writePage(w, r, values)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": []bitbucket.ErrorMessage{{Message: fmt.Sprintf(format, args...)}},
+	})
+}
+
+// writePage answers with the slice of values selected by the start and limit
+// query parameters, in the paged envelope Bitbucket uses
+func writePage(w http.ResponseWriter, r *http.Request, values []interface{}) {
+	start, _ := strconv.Atoi(r.URL.Query().Get("start"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = pageLimit
+	}
+	if start > len(values) {
+		start = len(values)
+	}
+	end := start + limit
+	if end > len(values) {
+		end = len(values)
+	}
+	data, _ := json.Marshal(values[start:end])
+	page := bitbucket.Page{
+		Size:       end - start,
+		Limit:      limit,
+		Start:      start,
+		IsLastPage: end == len(values),
+		Values:     data,
+	}
+	if !page.IsLastPage {
+		page.NextPageStart = end
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleRepo routes /rest/api/1.0/projects/{project}/repos/{repo}/...
+func (s *Server) handleRepo(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/rest/api/1.0/projects/"), "/")
+	if len(parts) < 4 || parts[1] != "repos" {
+		writeError(w, http.StatusNotFound, "no such resource %s", r.URL.Path)
+		return
+	}
+	project, slug, rest := parts[0], parts[2], parts[3:]
+
+	repo, err := git.PlainOpen(s.RepoPath(project, slug))
+	if err != nil {
+		writeError(w, http.StatusNotFound, "repository %s/%s does not exist", project, slug)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case rest[0] == "branches" && len(rest) == 1 && r.Method == "GET":
+		s.listBranches(w, r, repo)
+	case rest[0] == "branches" && len(rest) == 1 && r.Method == "POST":
+		s.createBranch(w, r, repo)
+	case rest[0] == "branches" && len(rest) == 2 && rest[1] == "default":
+		s.defaultBranch(w, repo)
+	case rest[0] == "pull-requests" && len(rest) == 1 && r.Method == "GET":
+		s.listPullRequests(w, r, project+"/"+slug)
+	case rest[0] == "pull-requests" && len(rest) == 1 && r.Method == "POST":
+		s.createPullRequest(w, r, repo, project, slug)
+	case rest[0] == "pull-requests" && len(rest) >= 2:
+		id, err := strconv.Atoi(rest[1])
+		pr := s.findPullRequest(project+"/"+slug, id)
+		if err != nil || pr == nil {
+			writeError(w, http.StatusNotFound, "pull request %s does not exist", rest[1])
+			return
+		}
+		for _, ref := range []*bitbucket.Ref{&pr.FromRef, &pr.ToRef} {
+			if tip, err := repo.Reference(plumbing.ReferenceName(ref.ID), true); err == nil && pr.State == "OPEN" {
+				ref.LatestCommit = tip.Hash().String()
+			}
+		}
+		s.handlePullRequest(w, r, repo, pr, fmt.Sprintf("%s/%s/%d", project, slug, id), rest[2:])
+	default:
+		writeError(w, http.StatusNotFound, "no such resource %s", r.URL.Path)
+	}
+}
+
+func branchRef(ref *plumbing.Reference, isDefault bool) bitbucket.Branch {
+	return bitbucket.Branch{
+		ID:           ref.Name().String(),
+		DisplayID:    ref.Name().Short(),
+		Type:         "BRANCH",
+		LatestCommit: ref.Hash().String(),
+		IsDefault:    isDefault,
+	}
+}
+
+func defaultBranchName(repo *git.Repository) plumbing.ReferenceName {
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return ""
+	}
+	return head.Target()
+}
+
+func (s *Server) listBranches(w http.ResponseWriter, r *http.Request, repo *git.Repository) {
+	filter := r.URL.Query().Get("filterText")
+	refs, err := repo.Branches()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	def := defaultBranchName(repo)
+	var values []interface{}
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if strings.Contains(ref.Name().Short(), filter) {
+			values = append(values, branchRef(ref, ref.Name() == def))
+		}
+		return nil
+	})
+	writePage(w, r, values)
+}
+
+func (s *Server) defaultBranch(w http.ResponseWriter, repo *git.Repository) {
+	ref, err := repo.Reference(defaultBranchName(repo), true)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "no default branch")
+		return
+	}
+	writeJSON(w, http.StatusOK, branchRef(ref, true))
+}
+
+// resolve finds a start point given as a branch name, a ref or a commit id
+func resolve(repo *git.Repository, startPoint string) (plumbing.Hash, error) {
+	for _, name := range []plumbing.ReferenceName{plumbing.ReferenceName(startPoint), plumbing.NewBranchReferenceName(startPoint)} {
+		ref, err := repo.Reference(name, true)
+		if err == nil {
+			return ref.Hash(), nil
+		}
+	}
+	hash := plumbing.NewHash(startPoint)
+	_, err := repo.CommitObject(hash)
+	return hash, err
+}
+
+func (s *Server) createBranch(w http.ResponseWriter, r *http.Request, repo *git.Repository) {
+	var body bitbucket.CreateBranch
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	name := plumbing.NewBranchReferenceName(strings.TrimPrefix(body.Name, "refs/heads/"))
+	if _, err := repo.Reference(name, false); err == nil {
+		writeError(w, http.StatusConflict, "branch %s already exists", body.Name)
+		return
+	}
+	hash, err := resolve(repo, body.StartPoint)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "start point %s does not exist", body.StartPoint)
+		return
+	}
+	ref := plumbing.NewHashReference(name, hash)
+	err = repo.Storer.SetReference(ref)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "%s", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, branchRef(ref, false))
+}
+
+func (s *Server) findPullRequest(key string, id int) *bitbucket.PullRequest {
+	for _, pr := range s.pullRequests[key] {
+		if pr.ID == id {
+			return pr
+		}
+	}
+	return nil
+}
+
+func (s *Server) listPullRequests(w http.ResponseWriter, r *http.Request, key string) {
+	query := r.URL.Query()
+	state := query.Get("state")
+	if state == "" {
+		state = "OPEN"
+	}
+	at := query.Get("at")
+	direction := query.Get("direction")
+	var values []interface{}
+	for _, pr := range s.pullRequests[key] {
+		if state != "ALL" && pr.State != state {
+			continue
+		}
+		if at != "" && direction == "OUTGOING" && pr.FromRef.ID != at {
+			continue
+		}
+		if at != "" && direction != "OUTGOING" && pr.ToRef.ID != at {
+			continue
+		}
+		values = append(values, pr)
+	}
+	writePage(w, r, values)
+}
+
+func (s *Server) createPullRequest(w http.ResponseWriter, r *http.Request, repo *git.Repository, project string, slug string) {
+	var pr bitbucket.PullRequest
+	err := json.NewDecoder(r.Body).Decode(&pr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	for _, ref := range []bitbucket.Ref{pr.FromRef, pr.ToRef} {
+		if _, err := repo.Reference(plumbing.ReferenceName(ref.ID), false); err != nil {
+			writeError(w, http.StatusBadRequest, "ref %s does not exist", ref.ID)
+			return
+		}
+	}
+	key := project + "/" + slug
+	for _, existing := range s.pullRequests[key] {
+		if existing.State == "OPEN" && existing.FromRef.ID == pr.FromRef.ID && existing.ToRef.ID == pr.ToRef.ID {
+			writeError(w, http.StatusConflict, "only one pull request may be open for a given source and target branch")
+			return
+		}
+	}
+	s.nextID++
+	pr.ID = s.nextID
+	pr.Version = 0
+	pr.State = "OPEN"
+	pr.Open = true
+	pr.Links = &struct {
+		Self []bitbucket.Link `json:"self"`
+	}{Self: []bitbucket.Link{{Href: fmt.Sprintf("%s/projects/%s/repos/%s/pull-requests/%d", s.URL, project, slug, pr.ID)}}}
+	s.pullRequests[key] = append(s.pullRequests[key], &pr)
+	writeJSON(w, http.StatusCreated, pr)
+}
+
+// mergeStatus vetoes a merge while approvals are missing, a reviewer asked for
+// changes or a build of the source branch tip is not successful
+func (s *Server) mergeStatus(pr *bitbucket.PullRequest) bitbucket.MergeStatus {
+	status := bitbucket.MergeStatus{}
+	approvals := 0
+	for _, reviewer := range pr.Reviewers {
+		if reviewer.Approved {
+			approvals++
+		}
+		if reviewer.Status == bitbucket.ReviewerNeedsWork {
+			status.Vetoes = append(status.Vetoes, bitbucket.MergeVeto{SummaryMessage: fmt.Sprintf("%s marked the pull request as needs work", reviewer.User.Name)})
+		}
+	}
+	if approvals < s.RequiredApprovals {
+		status.Vetoes = append(status.Vetoes, bitbucket.MergeVeto{SummaryMessage: fmt.Sprintf("Requires %d approvals", s.RequiredApprovals)})
+	}
+	for _, build := range s.builds[pr.FromRef.LatestCommit] {
+		if build.State != bitbucket.BuildSuccessful {
+			status.Vetoes = append(status.Vetoes, bitbucket.MergeVeto{SummaryMessage: fmt.Sprintf("Build %s is %s", build.Key, build.State)})
+		}
+	}
+	status.CanMerge = len(status.Vetoes) == 0 && pr.State == "OPEN"
+	if status.CanMerge {
+		status.Outcome = "CLEAN"
+	}
+	return status
+}
+
+func (s *Server) handlePullRequest(w http.ResponseWriter, r *http.Request, repo *git.Repository, pr *bitbucket.PullRequest, key string, rest []string) {
+	switch {
+	case len(rest) == 0 && r.Method == "GET":
+		writeJSON(w, http.StatusOK, pr)
+	case len(rest) == 0 && r.Method == "PUT":
+		var update bitbucket.PullRequest
+		err := json.NewDecoder(r.Body).Decode(&update)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		if update.Version != pr.Version {
+			writeError(w, http.StatusConflict, "pull request is at version %d, not %d", pr.Version, update.Version)
+			return
+		}
+		pr.Title = update.Title
+		pr.Description = update.Description
+		pr.Reviewers = update.Reviewers
+		pr.Version++
+		writeJSON(w, http.StatusOK, pr)
+	case len(rest) == 1 && rest[0] == "merge" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, s.mergeStatus(pr))
+	case len(rest) == 1 && rest[0] == "merge" && r.Method == "POST":
+		version, _ := strconv.Atoi(r.URL.Query().Get("version"))
+		if version != pr.Version {
+			writeError(w, http.StatusConflict, "pull request is at version %d, not %d", pr.Version, version)
+			return
+		}
+		status := s.mergeStatus(pr)
+		if !status.CanMerge {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{
+				"errors": []interface{}{map[string]interface{}{"message": "merge checks failed", "vetoes": status.Vetoes}},
+			})
+			return
+		}
+		// the fake fast forwards the target onto the source branch
+		err := repo.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(pr.ToRef.ID), plumbing.NewHash(pr.FromRef.LatestCommit)))
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "%s", err)
+			return
+		}
+		pr.State = "MERGED"
+		pr.Open = false
+		pr.Version++
+		writeJSON(w, http.StatusOK, pr)
+	case len(rest) == 1 && rest[0] == "decline" && r.Method == "POST":
+		version, _ := strconv.Atoi(r.URL.Query().Get("version"))
+		if version != pr.Version {
+			writeError(w, http.StatusConflict, "pull request is at version %d, not %d", pr.Version, version)
+			return
+		}
+		if pr.State != "OPEN" {
+			writeError(w, http.StatusConflict, "pull request is %s", pr.State)
+			return
+		}
+		pr.State = "DECLINED"
+		pr.Open = false
+		pr.Version++
+		writeJSON(w, http.StatusOK, pr)
+	case len(rest) == 1 && rest[0] == "comments" && r.Method == "POST":
+		var comment bitbucket.Comment
+		err := json.NewDecoder(r.Body).Decode(&comment)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+		comment.ID = len(s.comments[key]) + 1
+		s.comments[key] = append(s.comments[key], comment)
+		writeJSON(w, http.StatusCreated, comment)
+	default:
+		writeError(w, http.StatusNotFound, "no such resource %s", r.URL.Path)
+	}
+}
@@ -0,0 +1,132 @@
+package fake
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHServer serves the repositories under Root over SSH by running git
+// upload-pack and receive-pack, for clients holding the authorized key
+type SSHServer struct {
+	Root       string
+	listener   net.Listener
+	hostKey    ssh.Signer
+	authorized ssh.PublicKey
+}
+
+// NewSSHServer listens on a local port with a fresh host key
+func NewSSHServer(root string, authorized ssh.PublicKey) (*SSHServer, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &SSHServer{Root: root, listener: listener, hostKey: hostKey, authorized: authorized}
+	go s.serve()
+	return s, nil
+}
+
+// Close stops accepting connections
+func (s *SSHServer) Close() error {
+	return s.listener.Close()
+}
+
+// CloneUrl is an ssh clone url template pointing at the repositories under Root
+func (s *SSHServer) CloneUrl() string {
+	return fmt.Sprintf("ssh://git@%s/{project}/{repo}.git", s.listener.Addr())
+}
+
+// KnownHosts is the known_hosts line for the server's host key
+func (s *SSHServer) KnownHosts() string {
+	return knownhosts.Line([]string{knownhosts.Normalize(s.listener.Addr().String())}, s.hostKey.PublicKey()) + "\n"
+}
+
+func (s *SSHServer) serve() {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), s.authorized.Marshal()) {
+				return nil, fmt.Errorf("unknown key for %s", conn.User())
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(s.hostKey)
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn, config)
+	}
+}
+
+func (s *SSHServer) handle(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(channel, requests)
+	}
+}
+
+// session runs the git command of the first exec request, e.g.
+// git-upload-pack '/PROJECT/repo.git'
+func (s *SSHServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		err := ssh.Unmarshal(req.Payload, &payload)
+		fields := strings.SplitN(payload.Command, " ", 2)
+		if err != nil || len(fields) != 2 || (fields[0] != "git-upload-pack" && fields[0] != "git-receive-pack") {
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+		path := strings.Trim(fields[1], "'\"")
+		cmd := exec.Command("git", strings.TrimPrefix(fields[0], "git-"), filepath.Join(s.Root, filepath.FromSlash(path)))
+		cmd.Stdin = channel
+		cmd.Stdout = channel
+		cmd.Stderr = channel.Stderr()
+		status := uint32(0)
+		if err := cmd.Run(); err != nil {
+			status = 1
+		}
+		channel.CloseWrite()
+		exit := make([]byte, 4)
+		binary.BigEndian.PutUint32(exit, status)
+		channel.SendRequest("exit-status", false, exit)
+		return
+	}
+}
@@ -0,0 +1,32 @@
+package bitbucket
+
+import (
+	"fmt"
+	"net/url"
+)
+
+func (c *Client) reportUrl(project string, repo string, commit string, key string) string {
+	return fmt.Sprintf("%s/projects/%s/repos/%s/commits/%s/reports/%s", c.restUrl("insights/1.0"),
+		url.PathEscape(project), url.PathEscape(repo), url.PathEscape(commit), url.PathEscape(key))
+}
+
+// CreateReport creates or replaces the Code Insights report key on a commit
+func (c *Client) CreateReport(project string, repo string, commit string, key string, report Report) (*Report, error) {
+	var created Report
+	err := c.Do("PUT", c.reportUrl(project, repo, commit, key), report, &created)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// SetAnnotations replaces the annotations of report key, removing the ones an
+// earlier run left behind
+func (c *Client) SetAnnotations(project string, repo string, commit string, key string, annotations []Annotation) error {
+	u := c.reportUrl(project, repo, commit, key) + "/annotations"
+	err := c.Do("DELETE", u, nil, nil)
+	if err != nil || len(annotations) == 0 {
+		return err
+	}
+	return c.Do("POST", u, map[string][]Annotation{"annotations": annotations}, nil)
+}
@@ -0,0 +1,181 @@
+package bitbucket
+
+import "encoding/json"
+
+// Page is the envelope of every paged collection
+type Page struct {
+	Size          int             `json:"size"`
+	Limit         int             `json:"limit"`
+	Start         int             `json:"start"`
+	IsLastPage    bool            `json:"isLastPage"`
+	NextPageStart int             `json:"nextPageStart"`
+	Values        json.RawMessage `json:"values"`
+}
+
+type Project struct {
+	Key  string `json:"key"`
+	Name string `json:"name,omitempty"`
+}
+
+type Repository struct {
+	Slug    string   `json:"slug"`
+	Name    string   `json:"name,omitempty"`
+	Project *Project `json:"project,omitempty"`
+}
+
+// Ref is a branch or tag. Branch listings fill in LatestCommit and IsDefault,
+// pull requests fill in Repository.
+type Ref struct {
+	ID           string      `json:"id"`
+	DisplayID    string      `json:"displayId,omitempty"`
+	Type         string      `json:"type,omitempty"`
+	LatestCommit string      `json:"latestCommit,omitempty"`
+	IsDefault    bool        `json:"isDefault,omitempty"`
+	Repository   *Repository `json:"repository,omitempty"`
+}
+
+type Branch = Ref
+
+type CreateBranch struct {
+	Name       string `json:"name"`
+	StartPoint string `json:"startPoint"`
+	Message    string `json:"message,omitempty"`
+}
+
+type User struct {
+	Name         string `json:"name"`
+	EmailAddress string `json:"emailAddress,omitempty"`
+	DisplayName  string `json:"displayName,omitempty"`
+	Slug         string `json:"slug,omitempty"`
+}
+
+type Participant struct {
+	User     User   `json:"user"`
+	Role     string `json:"role,omitempty"`
+	Approved bool   `json:"approved,omitempty"`
+	Status   string `json:"status,omitempty"`
+}
+
+type Link struct {
+	Href string `json:"href"`
+	Name string `json:"name,omitempty"`
+}
+
+type PullRequest struct {
+	ID           int           `json:"id,omitempty"`
+	Version      int           `json:"version"`
+	Title        string        `json:"title"`
+	Description  string        `json:"description"`
+	State        string        `json:"state,omitempty"`
+	Open         bool          `json:"open,omitempty"`
+	FromRef      Ref           `json:"fromRef"`
+	ToRef        Ref           `json:"toRef"`
+	Author       *Participant  `json:"author,omitempty"`
+	Reviewers    []Participant `json:"reviewers,omitempty"`
+	Participants []Participant `json:"participants,omitempty"`
+	Links        *struct {
+		Self []Link `json:"self"`
+	} `json:"links,omitempty"`
+}
+
+// Url returns the web link of the pull request, if Bitbucket sent one
+func (p PullRequest) Url() string {
+	if p.Links == nil || len(p.Links.Self) == 0 {
+		return ""
+	}
+	return p.Links.Self[0].Href
+}
+
+type Commit struct {
+	ID              string   `json:"id"`
+	DisplayID       string   `json:"displayId"`
+	Message         string   `json:"message"`
+	Author          User     `json:"author"`
+	AuthorTimestamp int64    `json:"authorTimestamp"`
+	Committer       User     `json:"committer"`
+	Parents         []Commit `json:"parents,omitempty"`
+}
+
+type Comment struct {
+	ID      int    `json:"id,omitempty"`
+	Version int    `json:"version,omitempty"`
+	Text    string `json:"text"`
+	Author  *User  `json:"author,omitempty"`
+}
+
+// Build states accepted by the build status API
+const (
+	BuildSuccessful = "SUCCESSFUL"
+	BuildFailed     = "FAILED"
+	BuildInProgress = "INPROGRESS"
+)
+
+type BuildStatus struct {
+	State       string `json:"state"`
+	Key         string `json:"key"`
+	Name        string `json:"name,omitempty"`
+	Url         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+// Reviewer statuses
+const (
+	ReviewerApproved   = "APPROVED"
+	ReviewerNeedsWork  = "NEEDS_WORK"
+	ReviewerUnapproved = "UNAPPROVED"
+)
+
+type MergeVeto struct {
+	SummaryMessage  string `json:"summaryMessage"`
+	DetailedMessage string `json:"detailedMessage,omitempty"`
+}
+
+// MergeStatus tells whether a pull request can be merged now and, if not, which
+// merge checks veto it
+type MergeStatus struct {
+	CanMerge   bool        `json:"canMerge"`
+	Conflicted bool        `json:"conflicted"`
+	Outcome    string      `json:"outcome,omitempty"`
+	Vetoes     []MergeVeto `json:"vetoes"`
+}
+
+type Merge struct {
+	StrategyID string `json:"strategyId,omitempty"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Code Insights report results and annotation severities
+const (
+	ReportPass     = "PASS"
+	ReportFail     = "FAIL"
+	SeverityLow    = "LOW"
+	SeverityMedium = "MEDIUM"
+	SeverityHigh   = "HIGH"
+)
+
+// ReportData is one of the (at most six) values shown on a Code Insights
+// report. Type is BOOLEAN, DATE, DURATION, LINK, NUMBER, PERCENTAGE or TEXT.
+type ReportData struct {
+	Title string      `json:"title"`
+	Type  string      `json:"type,omitempty"`
+	Value interface{} `json:"value"`
+}
+
+type Report struct {
+	Title    string       `json:"title"`
+	Details  string       `json:"details,omitempty"`
+	Result   string       `json:"result,omitempty"`
+	Reporter string       `json:"reporter,omitempty"`
+	Link     string       `json:"link,omitempty"`
+	Data     []ReportData `json:"data,omitempty"`
+}
+
+// Annotation points a report finding at a file, Line 0 annotates the whole
+// file
+type Annotation struct {
+	Path     string `json:"path"`
+	Line     int    `json:"line"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+	Type     string `json:"type,omitempty"`
+}
@@ -0,0 +1,84 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// PullRequestFilter narrows a pull request listing. Empty fields are not sent.
+type PullRequestFilter struct {
+	State     string
+	Direction string
+	At        string
+	Text      string
+}
+
+func (f PullRequestFilter) query() url.Values {
+	query := url.Values{}
+	for k, v := range map[string]string{"state": f.State, "direction": f.Direction, "at": f.At, "filterText": f.Text} {
+		if v != "" {
+			query.Set(k, v)
+		}
+	}
+	return query
+}
+
+func (c *Client) PullRequests(project string, repo string, filter PullRequestFilter) ([]PullRequest, error) {
+	var all []PullRequest
+	err := c.getPaged(fmt.Sprintf("%s/pull-requests", c.repoUrl(project, repo)), filter.query(), func(values json.RawMessage) error {
+		var prs []PullRequest
+		err := json.Unmarshal(values, &prs)
+		all = append(all, prs...)
+		return err
+	})
+	return all, err
+}
+
+func (c *Client) PullRequest(project string, repo string, id int) (*PullRequest, error) {
+	var pr PullRequest
+	err := c.Do("GET", fmt.Sprintf("%s/pull-requests/%d", c.repoUrl(project, repo), id), nil, &pr)
+	return &pr, err
+}
+
+func (c *Client) CreatePullRequest(project string, repo string, pr PullRequest) (*PullRequest, error) {
+	var created PullRequest
+	err := c.Do("POST", fmt.Sprintf("%s/pull-requests", c.repoUrl(project, repo)), pr, &created)
+	return &created, err
+}
+
+// UpdatePullRequest saves pr, which must carry the version it was read at.
+// Bitbucket answers a stale version with a 409, see IsConflict.
+func (c *Client) UpdatePullRequest(project string, repo string, pr PullRequest) (*PullRequest, error) {
+	var updated PullRequest
+	err := c.Do("PUT", fmt.Sprintf("%s/pull-requests/%d", c.repoUrl(project, repo), pr.ID), pr, &updated)
+	return &updated, err
+}
+
+func (c *Client) AddComment(project string, repo string, id int, text string) (*Comment, error) {
+	var created Comment
+	err := c.Do("POST", fmt.Sprintf("%s/pull-requests/%d/comments", c.repoUrl(project, repo), id), Comment{Text: text}, &created)
+	return &created, err
+}
+
+// CanMerge runs the merge checks of a pull request
+func (c *Client) CanMerge(project string, repo string, id int) (*MergeStatus, error) {
+	var status MergeStatus
+	err := c.Do("GET", fmt.Sprintf("%s/pull-requests/%d/merge", c.repoUrl(project, repo), id), nil, &status)
+	return &status, err
+}
+
+// MergePullRequest merges the pull request at version. An empty strategy uses
+// the repository's default merge strategy.
+func (c *Client) MergePullRequest(project string, repo string, id int, version int, strategy string) (*PullRequest, error) {
+	var merged PullRequest
+	err := c.Do("POST", fmt.Sprintf("%s/pull-requests/%d/merge?version=%d", c.repoUrl(project, repo), id, version), Merge{StrategyID: strategy}, &merged)
+	return &merged, err
+}
+
+// DeclinePullRequest declines the pull request at version
+func (c *Client) DeclinePullRequest(project string, repo string, id int, version int) (*PullRequest, error) {
+	var declined PullRequest
+	err := c.Do("POST", fmt.Sprintf("%s/pull-requests/%d/decline?version=%d", c.repoUrl(project, repo), id, version), struct{}{}, &declined)
+	return &declined, err
+}
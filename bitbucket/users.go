@@ -0,0 +1,22 @@
+package bitbucket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// GroupMembers lists the users of a group, e.g. to expand a reviewer group
+// into the individual reviewers a pull request needs
+func (c *Client) GroupMembers(group string) ([]User, error) {
+	var all []User
+	query := url.Values{}
+	query.Set("context", group)
+	err := c.getPaged(fmt.Sprintf("%s/admin/groups/more-members", c.ApiUrl), query, func(values json.RawMessage) error {
+		var users []User
+		err := json.Unmarshal(values, &users)
+		all = append(all, users...)
+		return err
+	})
+	return all, err
+}
@@ -0,0 +1,472 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/internal/rest"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const bitbucketCloudApiUrl = "https://api.bitbucket.org/2.0"
+
+// BitbucketCloud talks to bitbucket.org. Repositories are addressed by
+// workspace and repo slug. With basic auth the password is an app password,
+// with token auth a repository/workspace access token.
+type BitbucketCloud struct {
+	Workspace   string
+	ApiUrl      string
+	CloneUrl    string
+	Credentials CredentialSource
+	SSH         SSHOptions
+}
+
+type bitbucketCloudBranch struct {
+	Name   string `json:"name"`
+	Target struct {
+		Hash string `json:"hash"`
+	} `json:"target"`
+}
+
+type bitbucketCloudBranchRef struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+}
+
+type bitbucketCloudPullRequest struct {
+	ID          int                     `json:"id,omitempty"`
+	Title       string                  `json:"title"`
+	Description string                  `json:"description"`
+	State       string                  `json:"state,omitempty"`
+	Source      bitbucketCloudBranchRef `json:"source"`
+	Destination bitbucketCloudBranchRef `json:"destination"`
+	Reviewers   []bitbucketCloudUser    `json:"reviewers,omitempty"`
+	Links       *struct {
+		Html struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links,omitempty"`
+}
+
+// bitbucketCloudUser identifies a user by uuid ({...}) or account id
+type bitbucketCloudUser struct {
+	UUID      string `json:"uuid,omitempty"`
+	AccountID string `json:"account_id,omitempty"`
+}
+
+type bitbucketCloudPullRequestPage struct {
+	Values []bitbucketCloudPullRequest `json:"values"`
+	Next   string                      `json:"next"`
+}
+
+func NewBitbucketCloud(workspace string, apiUrl string, cloneUrl string, credentials CredentialSource, ssh SSHOptions) BitbucketCloud {
+	if apiUrl == "" {
+		apiUrl = bitbucketCloudApiUrl
+	}
+	b := BitbucketCloud{Workspace: workspace, ApiUrl: strings.TrimSuffix(apiUrl, "/"), CloneUrl: cloneUrl, Credentials: credentials, SSH: ssh}
+	b.Credentials = credentials.ForUrls(b.httpsCloneURL(""), b.ApiUrl)
+	return b
+}
+
+func (b BitbucketCloud) do(method string, path string, in interface{}, out interface{}) error {
+	creds, err := b.Credentials.Resolve()
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Authorization", creds.Header())
+	return rest.Client{Service: providerBitbucketCloud, Header: header}.Do(method, b.ApiUrl+path, in, out)
+}
+
+func (b BitbucketCloud) repoPath(repoSlug string) string {
+	return fmt.Sprintf("/repositories/%s/%s", url.PathEscape(b.Workspace), url.PathEscape(repoSlug))
+}
+
+// branch looks up a branch, a 404 is returned as is for the callers to read
+func (b BitbucketCloud) branch(repoSlug string, name string) (*bitbucketCloudBranch, error) {
+	var branch bitbucketCloudBranch
+	err := b.do("GET", fmt.Sprintf("%s/refs/branches/%s", b.repoPath(repoSlug), name), nil, &branch)
+	if err != nil || branch.Name != name {
+		return nil, err
+	}
+	return &branch, nil
+}
+
+func (b BitbucketCloud) DefaultBranch(repoSlug string) (string, error) {
+	var repo struct {
+		MainBranch struct {
+			Name string `json:"name"`
+		} `json:"mainbranch"`
+	}
+	err := b.do("GET", b.repoPath(repoSlug), nil, &repo)
+	if isNotFound(err) {
+		err = fmt.Errorf("repository %s/%s not found: %w", b.Workspace, repoSlug, err)
+	}
+	return repo.MainBranch.Name, err
+}
+
+func (b BitbucketCloud) BranchExists(repoSlug string, branch string) (bool, error) {
+	found, err := b.branch(repoSlug, branch)
+	if isNotFound(err) {
+		return false, nil
+	}
+	return found != nil, err
+}
+
+func (b BitbucketCloud) CreateBranch(repoSlug string, branch string, startPoint string) error {
+	start, err := b.branch(repoSlug, startPoint)
+	if isNotFound(err) {
+		return fmt.Errorf("start point %s not found in %s/%s: %w", startPoint, b.Workspace, repoSlug, err)
+	}
+	if err != nil {
+		return err
+	}
+	if start == nil {
+		return fmt.Errorf("start point %s not found in %s/%s", startPoint, b.Workspace, repoSlug)
+	}
+	body := bitbucketCloudBranch{Name: branch}
+	body.Target.Hash = start.Target.Hash
+	return b.do("POST", fmt.Sprintf("%s/refs/branches", b.repoPath(repoSlug)), body, nil)
+}
+
+func (b BitbucketCloud) FindPullRequest(repoSlug string, sourceBranch string, targetBranch string) (*PullRequest, error) {
+	var page bitbucketCloudPullRequestPage
+	query := url.Values{}
+	query.Set("q", fmt.Sprintf("source.branch.name=%q AND destination.branch.name=%q AND state=\"OPEN\"", sourceBranch, targetBranch))
+	err := b.do("GET", fmt.Sprintf("%s/pullrequests?%s", b.repoPath(repoSlug), query.Encode()), nil, &page)
+	if err != nil {
+		return nil, err
+	}
+	for _, pr := range page.Values {
+		if pr.Source.Branch.Name == sourceBranch && pr.Destination.Branch.Name == targetBranch {
+			found := &PullRequest{
+				ID:           pr.ID,
+				Title:        pr.Title,
+				Description:  pr.Description,
+				SourceBranch: sourceBranch,
+				TargetBranch: targetBranch,
+			}
+			if pr.Links != nil {
+				found.Url = pr.Links.Html.Href
+			}
+			return found, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListPullRequests returns the open pull requests into targetBranch
+func (b BitbucketCloud) ListPullRequests(repoSlug string, targetBranch string) ([]PullRequest, error) {
+	var open []PullRequest
+	query := url.Values{}
+	query.Set("q", fmt.Sprintf("destination.branch.name=%q AND state=\"OPEN\"", targetBranch))
+	next := fmt.Sprintf("%s/pullrequests?%s", b.repoPath(repoSlug), query.Encode())
+	for next != "" {
+		var page bitbucketCloudPullRequestPage
+		err := b.do("GET", next, nil, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range page.Values {
+			found := PullRequest{
+				ID:           pr.ID,
+				Title:        pr.Title,
+				Description:  pr.Description,
+				SourceBranch: pr.Source.Branch.Name,
+				TargetBranch: targetBranch,
+			}
+			if pr.Links != nil {
+				found.Url = pr.Links.Html.Href
+			}
+			open = append(open, found)
+		}
+		next = strings.TrimPrefix(page.Next, b.ApiUrl)
+	}
+	return open, nil
+}
+
+// reviewers adds the reviewers, given as uuids or account ids, to current.
+// Bitbucket Cloud has no group reviewers, @@groups are skipped.
+func (b BitbucketCloud) reviewers(current []bitbucketCloudUser, reviewers []string) []bitbucketCloudUser {
+	users, groups := splitReviewers(reviewers, b.Credentials.Username())
+	if len(groups) > 0 {
+		logger.Printf("bitbucket cloud has no group reviewers, ignoring %v", groups)
+	}
+	all := append([]bitbucketCloudUser(nil), current...)
+	for _, user := range users {
+		reviewer := bitbucketCloudUser{AccountID: user}
+		if strings.HasPrefix(user, "{") {
+			reviewer = bitbucketCloudUser{UUID: user}
+		}
+		known := false
+		for _, r := range all {
+			known = known || (r.UUID != "" && r.UUID == reviewer.UUID) || (r.AccountID != "" && r.AccountID == reviewer.AccountID)
+		}
+		if !known {
+			all = append(all, reviewer)
+		}
+	}
+	return all
+}
+
+func (b BitbucketCloud) OpenPullRequest(repoSlug string, pr PullRequest) error {
+	body := bitbucketCloudPullRequest{
+		Title:       pr.Title,
+		Description: pr.Description,
+		Reviewers:   b.reviewers(nil, pr.Reviewers),
+	}
+	body.Source.Branch.Name = pr.SourceBranch
+	body.Destination.Branch.Name = pr.TargetBranch
+
+	var created bitbucketCloudPullRequest
+	err := b.do("POST", fmt.Sprintf("%s/pullrequests", b.repoPath(repoSlug)), body, &created)
+	if err != nil {
+		return err
+	}
+	link := ""
+	if created.Links != nil {
+		link = created.Links.Html.Href
+	}
+	logger.Printf("opened pull request #%d %s", created.ID, link)
+	return nil
+}
+
+// UpdatePullRequest sends the reviewers the pull request has along with the
+// new ones, a PUT replaces them
+func (b BitbucketCloud) UpdatePullRequest(repoSlug string, pr PullRequest) error {
+	body := map[string]interface{}{"title": pr.Title, "description": pr.Description}
+	if len(pr.Reviewers) > 0 {
+		var current bitbucketCloudPullRequest
+		err := b.do("GET", fmt.Sprintf("%s/pullrequests/%d", b.repoPath(repoSlug), pr.ID), nil, &current)
+		if err != nil {
+			return err
+		}
+		body["reviewers"] = b.reviewers(current.Reviewers, pr.Reviewers)
+	}
+	return b.do("PUT", fmt.Sprintf("%s/pullrequests/%d", b.repoPath(repoSlug), pr.ID), body, nil)
+}
+
+func (b BitbucketCloud) CommentPullRequest(repoSlug string, pr PullRequest, text string) error {
+	body := map[string]interface{}{"content": map[string]string{"raw": text}}
+	return b.do("POST", fmt.Sprintf("%s/pullrequests/%d/comments", b.repoPath(repoSlug), pr.ID), body, nil)
+}
+
+func (b BitbucketCloud) DeclinePullRequest(repoSlug string, pr PullRequest) error {
+	return b.do("POST", fmt.Sprintf("%s/pullrequests/%d/decline", b.repoPath(repoSlug), pr.ID), nil, nil)
+}
+
+// requiredApprovals reads the approvals the branch restrictions require to
+// merge into branch. Reading them needs admin rights, without them, or without
+// a restriction, one approval is required so an unreviewed pull request is
+// never merged.
+func (b BitbucketCloud) requiredApprovals(repoSlug string, branch string) (int, error) {
+	var restrictions struct {
+		Values []struct {
+			Kind            string `json:"kind"`
+			BranchMatchKind string `json:"branch_match_kind"`
+			Pattern         string `json:"pattern"`
+			Value           int    `json:"value"`
+		} `json:"values"`
+	}
+	query := url.Values{}
+	query.Set("kind", "require_approvals_to_merge")
+	err := b.do("GET", fmt.Sprintf("%s/branch-restrictions?%s", b.repoPath(repoSlug), query.Encode()), nil, &restrictions)
+	if rest.IsStatus(err, http.StatusForbidden) || isNotFound(err) {
+		logger.Printf("can't read the branch restrictions of %s/%s, requiring one approval", b.Workspace, repoSlug)
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	required := 1
+	for _, restriction := range restrictions.Values {
+		// branching model restrictions are taken to apply, resolving the
+		// branch type isn't worth a lookup
+		matched := restriction.BranchMatchKind != "glob"
+		if !matched {
+			matched, _ = path.Match(restriction.Pattern, branch)
+		}
+		if matched && restriction.Value > required {
+			required = restriction.Value
+		}
+	}
+	return required, nil
+}
+
+// MergeStatus counts the approvals against the branch restrictions and looks
+// at the builds and reviews of the pull request. Bitbucket Cloud doesn't expose
+// its other merge checks, the merge itself enforces them.
+func (b BitbucketCloud) MergeStatus(repoSlug string, pr PullRequest) (*MergeStatus, error) {
+	var current struct {
+		Destination  bitbucketCloudBranchRef `json:"destination"`
+		Participants []struct {
+			State    string `json:"state"`
+			Approved bool   `json:"approved"`
+			User     struct {
+				DisplayName string `json:"display_name"`
+			} `json:"user"`
+		} `json:"participants"`
+	}
+	err := b.do("GET", fmt.Sprintf("%s/pullrequests/%d", b.repoPath(repoSlug), pr.ID), nil, &current)
+	if err != nil {
+		return nil, err
+	}
+	var builds struct {
+		Values []struct {
+			Key   string `json:"key"`
+			State string `json:"state"`
+		} `json:"values"`
+	}
+	err = b.do("GET", fmt.Sprintf("%s/pullrequests/%d/statuses", b.repoPath(repoSlug), pr.ID), nil, &builds)
+	if err != nil {
+		return nil, err
+	}
+	target := current.Destination.Branch.Name
+	if target == "" {
+		target = pr.TargetBranch
+	}
+	required, err := b.requiredApprovals(repoSlug, target)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MergeStatus{}
+	approvals := 0
+	for _, p := range current.Participants {
+		if p.Approved {
+			approvals++
+		}
+		if p.State == "changes_requested" {
+			status.Blocked = true
+			status.Reasons = append(status.Reasons, fmt.Sprintf("%s requested changes", p.User.DisplayName))
+		}
+	}
+	if approvals < required {
+		status.Reasons = append(status.Reasons, fmt.Sprintf("%d of %d required approvals", approvals, required))
+	}
+	for _, build := range builds.Values {
+		switch build.State {
+		case "FAILED", "STOPPED":
+			status.Blocked = true
+			status.Reasons = append(status.Reasons, fmt.Sprintf("build %s is %s", build.Key, build.State))
+		case "INPROGRESS":
+			status.Reasons = append(status.Reasons, fmt.Sprintf("build %s is in progress", build.Key))
+		}
+	}
+	status.Mergeable = len(status.Reasons) == 0
+	return status, nil
+}
+
+// MergePullRequest merges with strategy as the merge strategy: merge_commit,
+// squash or fast_forward
+func (b BitbucketCloud) MergePullRequest(repoSlug string, pr PullRequest, strategy string) error {
+	if strategy == "" {
+		strategy = "merge_commit"
+	}
+	body := map[string]string{"merge_strategy": strategy}
+	err := b.do("POST", fmt.Sprintf("%s/pullrequests/%d/merge", b.repoPath(repoSlug), pr.ID), body, nil)
+	if err != nil {
+		return err
+	}
+	logger.Printf("merged pull request #%d", pr.ID)
+	return nil
+}
+
+// ReportCommit posts a build status and a report with the findings as
+// annotations. Build statuses need a url, the commit page is used when the
+// report has none.
+func (b BitbucketCloud) ReportCommit(repoSlug string, commit string, report CommitReport) error {
+	commitPath := fmt.Sprintf("%s/commit/%s", b.repoPath(repoSlug), commit)
+	link := report.Url
+	if link == "" {
+		var current struct {
+			Links struct {
+				Html struct {
+					Href string `json:"href"`
+				} `json:"html"`
+			} `json:"links"`
+		}
+		err := b.do("GET", commitPath, nil, &current)
+		if err != nil {
+			return err
+		}
+		link = current.Links.Html.Href
+	}
+	state, result := "SUCCESSFUL", "PASSED"
+	if !report.Passed {
+		state, result = "FAILED", "FAILED"
+	}
+	status := map[string]string{
+		"key":         report.Key,
+		"state":       state,
+		"name":        report.Title,
+		"url":         link,
+		"description": report.Summary,
+	}
+	err := b.do("POST", commitPath+"/statuses/build", status, nil)
+	if err != nil {
+		return err
+	}
+
+	var data []map[string]string
+	for _, value := range report.Data {
+		data = append(data, map[string]string{"title": value.Title, "type": "TEXT", "value": value.Value})
+	}
+	body := map[string]interface{}{
+		"title":       report.Title,
+		"details":     report.Details,
+		"report_type": "TEST",
+		"reporter":    "auto-release-pr",
+		"link":        link,
+		"result":      result,
+		"data":        data,
+	}
+	reportPath := fmt.Sprintf("%s/reports/%s", commitPath, report.Key)
+	err = b.do("PUT", reportPath, body, nil)
+	if err != nil || len(report.Findings) == 0 {
+		return err
+	}
+	var annotations []map[string]string
+	for i, finding := range report.Findings {
+		annotations = append(annotations, map[string]string{
+			"external_id":     fmt.Sprintf("%s-%d", report.Key, i+1),
+			"annotation_type": "BUG",
+			"summary":         finding.Message,
+			"path":            finding.Path,
+			"severity":        finding.Severity,
+		})
+	}
+	return b.do("POST", reportPath+"/annotations", annotations, nil)
+}
+
+func (b BitbucketCloud) CloneURL(repoSlug string) string {
+	return b.SSH.CloneURL(repoSlug, b.Workspace, b.httpsCloneURL(repoSlug))
+}
+
+func (b BitbucketCloud) httpsCloneURL(repoSlug string) string {
+	if b.CloneUrl != "" {
+		return expandCloneUrl(b.CloneUrl, b.Workspace, repoSlug)
+	}
+	host := strings.TrimSuffix(b.ApiUrl, "/2.0")
+	if b.ApiUrl == bitbucketCloudApiUrl {
+		host = "https://bitbucket.org"
+	}
+	return fmt.Sprintf("%s/%s/%s.git", host, b.Workspace, repoSlug)
+}
+
+// Auth clones with the app password, or with the access token using the
+// x-token-auth user Bitbucket Cloud expects for token clones.
+func (b BitbucketCloud) Auth(cloneUrl string) (transport.AuthMethod, error) {
+	if isSSHUrl(cloneUrl) {
+		return b.SSH.Auth(cloneUrl)
+	}
+	creds, err := b.Credentials.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return creds.GitAuth("x-token-auth"), nil
+}
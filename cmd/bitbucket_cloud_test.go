@@ -0,0 +1,440 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeBitbucketCloud serves the parts of the Bitbucket Cloud REST API the
+// provider uses for a single repository. Restrictions are the branch
+// restrictions, which only a repository admin may read unless NotAdmin is set.
+type fakeBitbucketCloud struct {
+	fakeAPI
+	Workspace     string
+	Repo          string
+	DefaultBranch string
+	NotAdmin      bool
+	Restrictions  []fakeBitbucketCloudRestriction
+	Branches      map[string]string
+	PullRequests  []*fakeBitbucketCloudPullRequest
+	Builds        map[string][]map[string]string
+	Reports       map[string]map[string]interface{}
+	Annotations   map[string][]interface{}
+}
+
+type fakeBitbucketCloudPullRequest struct {
+	bitbucketCloudPullRequest
+	Participants []fakeBitbucketCloudParticipant
+	Builds       []map[string]string
+	Comments     []string
+	Merged       string
+}
+
+type fakeBitbucketCloudParticipant struct {
+	Role     string `json:"role"`
+	State    string `json:"state,omitempty"`
+	Approved bool   `json:"approved"`
+	User     struct {
+		DisplayName string `json:"display_name"`
+	} `json:"user"`
+}
+
+type fakeBitbucketCloudRestriction struct {
+	Kind            string `json:"kind"`
+	BranchMatchKind string `json:"branch_match_kind"`
+	Pattern         string `json:"pattern"`
+	Value           int    `json:"value"`
+}
+
+var bitbucketCloudQueryTerm = regexp.MustCompile(`([a-z.]+)="([^"]*)"`)
+
+func newFakeBitbucketCloud(t *testing.T) (*fakeBitbucketCloud, BitbucketCloud) {
+	t.Setenv(username, "release-bot")
+	t.Setenv(password, "app-password")
+	f := &fakeBitbucketCloud{
+		Workspace:     "acme",
+		Repo:          "gitops",
+		DefaultBranch: "main",
+		Branches:      map[string]string{"main": "1111111111111111111111111111111111111111"},
+		Builds:        map[string][]map[string]string{},
+		Reports:       map[string]map[string]interface{}{},
+		Annotations:   map[string][]interface{}{},
+	}
+	f.fakeAPI = fakeAPI{
+		Prefix: "/repositories/acme/gitops",
+		Header: "Authorization",
+		Token:  "Basic " + base64.StdEncoding.EncodeToString([]byte("release-bot:app-password")),
+		Handle: f.handle,
+	}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, NewBitbucketCloud(f.Workspace, server.URL, "", CredentialSource{Auth: authBasic}, SSHOptions{})
+}
+
+func (f *fakeBitbucketCloud) handle(w http.ResponseWriter, r *http.Request, path []string, fields map[string]interface{}, body interface{}) {
+	switch {
+	case r.Method == "GET" && path[0] == "":
+		writeJSON(w, map[string]interface{}{"mainbranch": map[string]string{"name": f.DefaultBranch}})
+	case r.Method == "GET" && path[0] == "branch-restrictions":
+		if f.NotAdmin {
+			writeError(w, http.StatusForbidden)
+			return
+		}
+		var values []fakeBitbucketCloudRestriction
+		for _, restriction := range f.Restrictions {
+			if restriction.Kind == r.URL.Query().Get("kind") {
+				values = append(values, restriction)
+			}
+		}
+		writeJSON(w, map[string]interface{}{"values": values})
+	case r.Method == "GET" && len(path) >= 3 && path[0] == "refs" && path[1] == "branches":
+		name := strings.Join(path[2:], "/")
+		hash, ok := f.Branches[name]
+		if !ok {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		branch := bitbucketCloudBranch{Name: name}
+		branch.Target.Hash = hash
+		writeJSON(w, branch)
+	case r.Method == "POST" && len(path) == 2 && path[0] == "refs":
+		target := fields["target"].(map[string]interface{})
+		f.Branches[fields["name"].(string)] = target["hash"].(string)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "GET" && len(path) == 1 && path[0] == "pullrequests":
+		terms := map[string]string{}
+		for _, term := range bitbucketCloudQueryTerm.FindAllStringSubmatch(r.URL.Query().Get("q"), -1) {
+			terms[term[1]] = term[2]
+		}
+		var page bitbucketCloudPullRequestPage
+		for _, pr := range f.PullRequests {
+			if pr.State == terms["state"] && pr.Destination.Branch.Name == terms["destination.branch.name"] &&
+				(terms["source.branch.name"] == "" || pr.Source.Branch.Name == terms["source.branch.name"]) {
+				page.Values = append(page.Values, pr.bitbucketCloudPullRequest)
+			}
+		}
+		writeJSON(w, page)
+	case r.Method == "POST" && len(path) == 1 && path[0] == "pullrequests":
+		data, _ := json.Marshal(fields)
+		pr := &fakeBitbucketCloudPullRequest{}
+		_ = json.Unmarshal(data, &pr.bitbucketCloudPullRequest)
+		pr.ID = len(f.PullRequests) + 1
+		pr.State = "OPEN"
+		pr.Links = &struct {
+			Html struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		}{}
+		pr.Links.Html.Href = fmt.Sprintf("https://bitbucket.example.com/%s/%s/pull-requests/%d", f.Workspace, f.Repo, pr.ID)
+		f.PullRequests = append(f.PullRequests, pr)
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, pr.bitbucketCloudPullRequest)
+	case len(path) >= 2 && path[0] == "pullrequests":
+		pr := f.pullRequest(path[1])
+		if pr == nil {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.Method == "GET" && len(path) == 2:
+			writeJSON(w, map[string]interface{}{
+				"id":           pr.ID,
+				"state":        pr.State,
+				"reviewers":    pr.Reviewers,
+				"destination":  pr.Destination,
+				"participants": pr.Participants,
+			})
+		case r.Method == "PUT" && len(path) == 2:
+			if title, ok := fields["title"].(string); ok {
+				pr.Title = title
+			}
+			if description, ok := fields["description"].(string); ok {
+				pr.Description = description
+			}
+			if reviewers, ok := fields["reviewers"]; ok {
+				data, _ := json.Marshal(reviewers)
+				pr.Reviewers = nil
+				_ = json.Unmarshal(data, &pr.Reviewers)
+			}
+			writeJSON(w, pr.bitbucketCloudPullRequest)
+		case r.Method == "GET" && len(path) == 3 && path[2] == "statuses":
+			writeJSON(w, map[string]interface{}{"values": pr.Builds})
+		case r.Method == "POST" && len(path) == 3 && path[2] == "comments":
+			content := fields["content"].(map[string]interface{})
+			pr.Comments = append(pr.Comments, content["raw"].(string))
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "POST" && len(path) == 3 && path[2] == "decline":
+			pr.State = "DECLINED"
+			writeJSON(w, pr.bitbucketCloudPullRequest)
+		case r.Method == "POST" && len(path) == 3 && path[2] == "merge":
+			pr.State = "MERGED"
+			pr.Merged = fields["merge_strategy"].(string)
+			writeJSON(w, pr.bitbucketCloudPullRequest)
+		default:
+			writeError(w, http.StatusNotFound)
+		}
+	case len(path) >= 2 && path[0] == "commit":
+		commit := path[1]
+		switch {
+		case r.Method == "GET" && len(path) == 2:
+			writeJSON(w, map[string]interface{}{"links": map[string]interface{}{
+				"html": map[string]string{"href": fmt.Sprintf("https://bitbucket.example.com/%s/%s/commits/%s", f.Workspace, f.Repo, commit)},
+			}})
+		case r.Method == "POST" && len(path) == 4 && path[2] == "statuses":
+			build := map[string]string{}
+			for k, v := range fields {
+				build[k] = v.(string)
+			}
+			f.Builds[commit] = append(f.Builds[commit], build)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "PUT" && len(path) == 4 && path[2] == "reports":
+			f.Reports[commit+"/"+path[3]] = fields
+			writeJSON(w, fields)
+		case r.Method == "POST" && len(path) == 5 && path[4] == "annotations":
+			annotations, _ := body.([]interface{})
+			f.Annotations[commit+"/"+path[3]] = append(f.Annotations[commit+"/"+path[3]], annotations...)
+			writeJSON(w, annotations)
+		default:
+			writeError(w, http.StatusNotFound)
+		}
+	default:
+		writeError(w, http.StatusNotFound)
+	}
+}
+
+func (f *fakeBitbucketCloud) pullRequest(id string) *fakeBitbucketCloudPullRequest {
+	n, _ := strconv.Atoi(id)
+	for _, pr := range f.PullRequests {
+		if pr.ID == n {
+			return pr
+		}
+	}
+	return nil
+}
+
+func TestBitbucketCloudBranches(t *testing.T) {
+	fake, bitbucket := newFakeBitbucketCloud(t)
+
+	branch, err := bitbucket.DefaultBranch(fake.Repo)
+	if err != nil || branch != "main" {
+		t.Fatalf("DefaultBranch = %q, %v, want main", branch, err)
+	}
+	exists, err := bitbucket.BranchExists(fake.Repo, "release/1.0")
+	if err != nil || exists {
+		t.Fatalf("BranchExists(release/1.0) = %v, %v before it was created", exists, err)
+	}
+	err = bitbucket.CreateBranch(fake.Repo, "release/1.0", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err = bitbucket.BranchExists(fake.Repo, "release/1.0")
+	if err != nil || !exists {
+		t.Fatalf("BranchExists(release/1.0) = %v, %v after it was created", exists, err)
+	}
+	if fake.Branches["release/1.0"] != fake.Branches["main"] {
+		t.Errorf("release/1.0 was created at %s, want the main commit", fake.Branches["release/1.0"])
+	}
+	err = bitbucket.CreateBranch(fake.Repo, "release/1.1", "develop")
+	if err == nil || !strings.Contains(err.Error(), "start point develop not found") {
+		t.Errorf("CreateBranch from a missing start point returned %v", err)
+	}
+}
+
+func TestBitbucketCloudPullRequests(t *testing.T) {
+	fake, bitbucket := newFakeBitbucketCloud(t)
+
+	pr, err := bitbucket.FindPullRequest(fake.Repo, "release/1.0", "main")
+	if err != nil || pr != nil {
+		t.Fatalf("FindPullRequest = %v, %v before it was opened", pr, err)
+	}
+	err = bitbucket.OpenPullRequest(fake.Repo, PullRequest{
+		Title:        "Release 1.0",
+		Description:  "first",
+		SourceBranch: "release/1.0",
+		TargetBranch: "main",
+		Reviewers:    []string{"{alice-uuid}", "@@backend"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err = bitbucket.FindPullRequest(fake.Repo, "release/1.0", "main")
+	if err != nil || pr == nil {
+		t.Fatalf("FindPullRequest = %v, %v after it was opened", pr, err)
+	}
+	if pr.ID != 1 || pr.Title != "Release 1.0" || pr.Description != "first" || pr.Url == "" {
+		t.Errorf("found %+v", pr)
+	}
+	other, err := bitbucket.FindPullRequest(fake.Repo, "release/1.0", "develop")
+	if err != nil || other != nil {
+		t.Errorf("FindPullRequest into develop = %v, %v", other, err)
+	}
+
+	pr.Title = "Release 1.0 (updated)"
+	pr.Description = "second"
+	pr.Reviewers = []string{"{alice-uuid}", "bob-account"}
+	err = bitbucket.UpdatePullRequest(fake.Repo, *pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bitbucket.CommentPullRequest(fake.Repo, *pr, "updated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := fake.PullRequests[0]
+	if opened.Title != "Release 1.0 (updated)" || opened.Description != "second" || opened.Destination.Branch.Name != "main" {
+		t.Errorf("pull request is %q: %q into %s after the update", opened.Title, opened.Description, opened.Destination.Branch.Name)
+	}
+	want := []bitbucketCloudUser{{UUID: "{alice-uuid}"}, {AccountID: "bob-account"}}
+	if fmt.Sprint(opened.Reviewers) != fmt.Sprint(want) {
+		t.Errorf("reviewers are %v, want %v", opened.Reviewers, want)
+	}
+	if strings.Join(opened.Comments, ",") != "updated" {
+		t.Errorf("comments are %v", opened.Comments)
+	}
+}
+
+func TestBitbucketCloudListPullRequests(t *testing.T) {
+	fake, bitbucket := newFakeBitbucketCloud(t)
+	testListPullRequests(t, &fake.fakeAPI, bitbucket, fake.Repo)
+}
+
+func TestBitbucketCloudReportCommit(t *testing.T) {
+	fake, bitbucket := newFakeBitbucketCloud(t)
+	err := bitbucket.ReportCommit(fake.Repo, "abc", CommitReport{
+		Key:      reportKey,
+		Title:    "Release",
+		Passed:   false,
+		Findings: []Finding{{Path: "product/.argocd/staging/api/config.yaml", Message: "downgrade", Severity: severityHigh}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if builds := fake.Builds["abc"]; len(builds) != 1 || builds[0]["state"] != "FAILED" || !strings.HasSuffix(builds[0]["url"], "/commits/abc") {
+		t.Errorf("build statuses are %v", builds)
+	}
+	if report := fake.Reports["abc/"+reportKey]; report == nil || report["result"] != "FAILED" {
+		t.Errorf("report is %v", report)
+	}
+	if annotations := fake.Annotations["abc/"+reportKey]; len(annotations) != 1 {
+		t.Errorf("annotations are %v", annotations)
+	}
+}
+
+func TestBitbucketCloudWriteErrors(t *testing.T) {
+	fake, bitbucket := newFakeBitbucketCloud(t)
+	err := bitbucket.OpenPullRequest(fake.Repo, PullRequest{Title: "Release 1.0", SourceBranch: "release/1.0", TargetBranch: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := bitbucket.FindPullRequest(fake.Repo, "release/1.0", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongWorkspace := bitbucket
+	wrongWorkspace.Workspace = "someone-else"
+	testWriteErrors(t, &fake.fakeAPI, bitbucket, wrongWorkspace, fake.Repo, *pr)
+	if _, ok := fake.Branches["release/1.1"]; ok || len(fake.PullRequests) != 1 || len(fake.PullRequests[0].Comments) != 0 || fake.PullRequests[0].State != "OPEN" {
+		t.Errorf("a failed write changed the repository")
+	}
+}
+
+func TestBitbucketCloudMergeStatus(t *testing.T) {
+	fake, bitbucket := newFakeBitbucketCloud(t)
+	err := bitbucket.OpenPullRequest(fake.Repo, PullRequest{Title: "Release 1.0", SourceBranch: "release/1.0", TargetBranch: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := PullRequest{ID: 1, SourceBranch: "release/1.0", TargetBranch: "main"}
+	current := fake.PullRequests[0]
+	participant := func(name string, approved bool, state string) fakeBitbucketCloudParticipant {
+		p := fakeBitbucketCloudParticipant{Role: "REVIEWER", Approved: approved, State: state}
+		p.User.DisplayName = name
+		return p
+	}
+
+	for _, c := range []struct {
+		name         string
+		notAdmin     bool
+		restrictions []fakeBitbucketCloudRestriction
+		participants []fakeBitbucketCloudParticipant
+		builds       []map[string]string
+		mergeable    bool
+		blocked      bool
+		reasons      string
+	}{
+		{
+			name:    "unapproved without restrictions",
+			reasons: "0 of 1 required approvals",
+		},
+		{
+			name:         "approved without restrictions",
+			participants: []fakeBitbucketCloudParticipant{participant("Alice", true, "approved")},
+			mergeable:    true,
+		},
+		{
+			name:         "short of the restriction on the target",
+			restrictions: []fakeBitbucketCloudRestriction{{Kind: "require_approvals_to_merge", BranchMatchKind: "glob", Pattern: "ma*", Value: 2}},
+			participants: []fakeBitbucketCloudParticipant{participant("Alice", true, "approved"), participant("Bob", false, "")},
+			reasons:      "1 of 2 required approvals",
+		},
+		{
+			name:         "restriction on another branch",
+			restrictions: []fakeBitbucketCloudRestriction{{Kind: "require_approvals_to_merge", BranchMatchKind: "glob", Pattern: "develop", Value: 2}},
+			participants: []fakeBitbucketCloudParticipant{participant("Alice", true, "approved")},
+			mergeable:    true,
+		},
+		{
+			name:         "restrictions not readable",
+			notAdmin:     true,
+			restrictions: []fakeBitbucketCloudRestriction{{Kind: "require_approvals_to_merge", BranchMatchKind: "glob", Pattern: "main", Value: 2}},
+			participants: []fakeBitbucketCloudParticipant{participant("Alice", true, "approved")},
+			mergeable:    true,
+		},
+		{
+			name:         "changes requested",
+			participants: []fakeBitbucketCloudParticipant{participant("Alice", true, "approved"), participant("Bob", false, "changes_requested")},
+			blocked:      true,
+			reasons:      "Bob requested changes",
+		},
+		{
+			name:         "build running",
+			participants: []fakeBitbucketCloudParticipant{participant("Alice", true, "approved")},
+			builds:       []map[string]string{{"key": "ci", "state": "INPROGRESS"}},
+			reasons:      "build ci is in progress",
+		},
+		{
+			name:    "build failed",
+			builds:  []map[string]string{{"key": "ci", "state": "FAILED"}},
+			blocked: true,
+			reasons: "0 of 1 required approvals; build ci is FAILED",
+		},
+	} {
+		fake.NotAdmin = c.notAdmin
+		fake.Restrictions = c.restrictions
+		current.Participants = c.participants
+		current.Builds = c.builds
+		status, err := bitbucket.MergeStatus(fake.Repo, pr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if status.Mergeable != c.mergeable || status.Blocked != c.blocked || strings.Join(status.Reasons, "; ") != c.reasons {
+			t.Errorf("%s: got %+v", c.name, status)
+		}
+	}
+
+	err = bitbucket.MergePullRequest(fake.Repo, pr, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.State != "MERGED" || current.Merged != "merge_commit" {
+		t.Errorf("pull request is %s with strategy %q, want merged with merge_commit", current.State, current.Merged)
+	}
+}
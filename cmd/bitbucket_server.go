@@ -0,0 +1,384 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/bitbucket"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// BitbucketServer talks to a Bitbucket Server / Data Center instance
+type BitbucketServer struct {
+	Project     string
+	ApiUrl      string
+	CloneUrl    string
+	Credentials CredentialSource
+	SSH         SSHOptions
+}
+
+func NewBitbucketServer(project string, apiUrl string, cloneUrl string, credentials CredentialSource, ssh SSHOptions) BitbucketServer {
+	credentials = credentials.ForUrls(expandCloneUrl(cloneUrl, project, ""), apiUrl)
+	return BitbucketServer{Project: project, ApiUrl: strings.TrimSuffix(apiUrl, "/"), CloneUrl: cloneUrl, Credentials: credentials, SSH: ssh}
+}
+
+// client authenticates with a username and password or with an HTTP access
+// token sent as a Bearer token
+func (b BitbucketServer) client() (*bitbucket.Client, error) {
+	creds, err := b.Credentials.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return bitbucket.NewClient(b.ApiUrl, creds.RestAuth()), nil
+}
+
+func (b BitbucketServer) DefaultBranch(repoSlug string) (string, error) {
+	client, err := b.client()
+	if err != nil {
+		return "", err
+	}
+	branch, err := client.DefaultBranch(b.Project, repoSlug)
+	if err != nil {
+		return "", err
+	}
+	return branch.DisplayID, nil
+}
+
+func (b BitbucketServer) BranchExists(repoSlug string, branch string) (bool, error) {
+	client, err := b.client()
+	if err != nil {
+		return false, err
+	}
+	found, err := client.Branch(b.Project, repoSlug, branch)
+	if err != nil {
+		return false, err
+	}
+	if found != nil {
+		logger.Printf("found branch %s at %s", found.ID, found.LatestCommit)
+	}
+	return found != nil, nil
+}
+
+func (b BitbucketServer) CreateBranch(repoSlug string, branch string, startPoint string) error {
+	client, err := b.client()
+	if err != nil {
+		return err
+	}
+	_, err = client.CreateBranch(b.Project, repoSlug, bitbucket.CreateBranch{
+		Message:    "Release Branch",
+		Name:       branch,
+		StartPoint: startPoint,
+	})
+	return err
+}
+
+func (b BitbucketServer) FindPullRequest(repoSlug string, sourceBranch string, targetBranch string) (*PullRequest, error) {
+	fromRef := fmt.Sprintf("refs/heads/%s", sourceBranch)
+	toRef := fmt.Sprintf("refs/heads/%s", targetBranch)
+
+	client, err := b.client()
+	if err != nil {
+		return nil, err
+	}
+	prs, err := client.PullRequests(b.Project, repoSlug, bitbucket.PullRequestFilter{
+		State:     "OPEN",
+		Direction: "OUTGOING",
+		At:        fromRef,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, pr := range prs {
+		if pr.FromRef.ID == fromRef && pr.ToRef.ID == toRef && pr.State == "OPEN" {
+			logger.Printf("found pull request %d: %s", pr.ID, pr.Title)
+			return &PullRequest{
+				ID:           pr.ID,
+				Version:      pr.Version,
+				Url:          pr.Url(),
+				Title:        pr.Title,
+				Description:  pr.Description,
+				SourceBranch: sourceBranch,
+				TargetBranch: targetBranch,
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListPullRequests returns the open pull requests into targetBranch
+func (b BitbucketServer) ListPullRequests(repoSlug string, targetBranch string) ([]PullRequest, error) {
+	client, err := b.client()
+	if err != nil {
+		return nil, err
+	}
+	prs, err := client.PullRequests(b.Project, repoSlug, bitbucket.PullRequestFilter{
+		State:     "OPEN",
+		Direction: "INCOMING",
+		At:        fmt.Sprintf("refs/heads/%s", targetBranch),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var open []PullRequest
+	for _, pr := range prs {
+		open = append(open, PullRequest{
+			ID:           pr.ID,
+			Version:      pr.Version,
+			Url:          pr.Url(),
+			Title:        pr.Title,
+			Description:  pr.Description,
+			SourceBranch: strings.TrimPrefix(pr.FromRef.ID, "refs/heads/"),
+			TargetBranch: targetBranch,
+		})
+	}
+	return open, nil
+}
+
+// reviewers expands @@groups into their members and adds everyone not in
+// current yet
+func (b BitbucketServer) reviewers(client *bitbucket.Client, current []bitbucket.Participant, reviewers []string) ([]bitbucket.Participant, error) {
+	users, groups := splitReviewers(reviewers, b.Credentials.Username())
+	for _, group := range groups {
+		members, err := client.GroupMembers(group)
+		if err != nil {
+			return nil, fmt.Errorf("reviewer group %s: %s", group, err)
+		}
+		for _, member := range members {
+			if member.Name != b.Credentials.Username() {
+				users = append(users, member.Name)
+			}
+		}
+	}
+	participants := append([]bitbucket.Participant(nil), current...)
+	for _, user := range users {
+		found := false
+		for _, p := range participants {
+			found = found || p.User.Name == user
+		}
+		if !found {
+			participants = append(participants, bitbucket.Participant{User: bitbucket.User{Name: user}})
+		}
+	}
+	return participants, nil
+}
+
+func (b BitbucketServer) OpenPullRequest(repoSlug string, pr PullRequest) error {
+	client, err := b.client()
+	if err != nil {
+		return err
+	}
+	reviewers, err := b.reviewers(client, nil, pr.Reviewers)
+	if err != nil {
+		return err
+	}
+	created, err := client.CreatePullRequest(b.Project, repoSlug, bitbucket.PullRequest{
+		FromRef:     bitbucket.Ref{ID: fmt.Sprintf("refs/heads/%s", pr.SourceBranch), Type: "BRANCH"},
+		ToRef:       bitbucket.Ref{ID: fmt.Sprintf("refs/heads/%s", pr.TargetBranch), Type: "BRANCH"},
+		Title:       pr.Title,
+		Description: pr.Description,
+		Reviewers:   reviewers,
+	})
+	if err != nil {
+		return err
+	}
+	logger.Printf("opened pull request %d %s", created.ID, created.Url())
+	return nil
+}
+
+// UpdatePullRequest re-reads the pull request so the PUT carries its current
+// version and reviewers, retrying once if someone else changed it in between
+func (b BitbucketServer) UpdatePullRequest(repoSlug string, pr PullRequest) error {
+	client, err := b.client()
+	if err != nil {
+		return err
+	}
+	for attempt := 0; attempt < 2; attempt++ {
+		var current *bitbucket.PullRequest
+		current, err = client.PullRequest(b.Project, repoSlug, pr.ID)
+		if err != nil {
+			return err
+		}
+		current.Title = pr.Title
+		current.Description = pr.Description
+		current.Reviewers, err = b.reviewers(client, current.Reviewers, pr.Reviewers)
+		if err != nil {
+			return err
+		}
+		_, err = client.UpdatePullRequest(b.Project, repoSlug, *current)
+		if !bitbucket.IsConflict(err) {
+			return err
+		}
+		logger.Printf("pull request %d changed while updating it, retrying", pr.ID)
+	}
+	return err
+}
+
+func (b BitbucketServer) CommentPullRequest(repoSlug string, pr PullRequest, text string) error {
+	client, err := b.client()
+	if err != nil {
+		return err
+	}
+	_, err = client.AddComment(b.Project, repoSlug, pr.ID, text)
+	return err
+}
+
+// MergeStatus runs the merge checks. Bitbucket vetoes don't say whether they
+// will clear, so conflicts, needs work reviews and failed builds of the source
+// branch are looked up to tell blocked from pending.
+func (b BitbucketServer) MergeStatus(repoSlug string, pr PullRequest) (*MergeStatus, error) {
+	client, err := b.client()
+	if err != nil {
+		return nil, err
+	}
+	current, err := client.PullRequest(b.Project, repoSlug, pr.ID)
+	if err != nil {
+		return nil, err
+	}
+	checks, err := client.CanMerge(b.Project, repoSlug, pr.ID)
+	if err != nil {
+		return nil, err
+	}
+	status := &MergeStatus{Mergeable: checks.CanMerge}
+	for _, veto := range checks.Vetoes {
+		status.Reasons = append(status.Reasons, veto.SummaryMessage)
+	}
+	if checks.Conflicted {
+		status.Blocked = true
+		status.Reasons = append(status.Reasons, "the pull request has conflicts")
+	}
+	for _, reviewer := range current.Reviewers {
+		if reviewer.Status == bitbucket.ReviewerNeedsWork {
+			status.Blocked = true
+		}
+	}
+	if current.FromRef.LatestCommit != "" {
+		builds, err := client.BuildStatuses(current.FromRef.LatestCommit)
+		if err != nil {
+			return nil, err
+		}
+		for _, build := range builds {
+			if build.State == bitbucket.BuildFailed {
+				status.Blocked = true
+			}
+		}
+	}
+	return status, nil
+}
+
+// MergePullRequest merges at the current version, strategy is a Bitbucket
+// merge strategy id such as no-ff, squash or ff-only
+func (b BitbucketServer) MergePullRequest(repoSlug string, pr PullRequest, strategy string) error {
+	client, err := b.client()
+	if err != nil {
+		return err
+	}
+	current, err := client.PullRequest(b.Project, repoSlug, pr.ID)
+	if err != nil {
+		return err
+	}
+	merged, err := client.MergePullRequest(b.Project, repoSlug, pr.ID, current.Version, strategy)
+	if err != nil {
+		return err
+	}
+	logger.Printf("merged pull request %d %s", merged.ID, merged.Url())
+	return nil
+}
+
+// DeclinePullRequest declines at the current version
+func (b BitbucketServer) DeclinePullRequest(repoSlug string, pr PullRequest) error {
+	client, err := b.client()
+	if err != nil {
+		return err
+	}
+	current, err := client.PullRequest(b.Project, repoSlug, pr.ID)
+	if err != nil {
+		return err
+	}
+	_, err = client.DeclinePullRequest(b.Project, repoSlug, pr.ID, current.Version)
+	return err
+}
+
+// webUrl is the address of the web pages: the scheme and host of the API url
+// and the context path before /rest, if Bitbucket is served under one
+func (b BitbucketServer) webUrl() string {
+	u, err := url.Parse(b.ApiUrl)
+	if err != nil {
+		return b.ApiUrl
+	}
+	path := u.Path
+	if i := strings.Index(path, "/rest/"); i >= 0 {
+		path = path[:i]
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: path}).String()
+}
+
+// ReportCommit posts a build status and a Code Insights report with the
+// findings as annotations on their app config files
+func (b BitbucketServer) ReportCommit(repoSlug string, commit string, report CommitReport) error {
+	client, err := b.client()
+	if err != nil {
+		return err
+	}
+	link := report.Url
+	if link == "" {
+		link = fmt.Sprintf("%s/projects/%s/repos/%s/commits/%s", b.webUrl(), b.Project, repoSlug, commit)
+	}
+	state, result := bitbucket.BuildSuccessful, bitbucket.ReportPass
+	if !report.Passed {
+		state, result = bitbucket.BuildFailed, bitbucket.ReportFail
+	}
+	err = client.SetBuildStatus(commit, bitbucket.BuildStatus{
+		State:       state,
+		Key:         report.Key,
+		Name:        report.Title,
+		Url:         link,
+		Description: report.Summary,
+	})
+	if err != nil {
+		return err
+	}
+
+	insights := bitbucket.Report{
+		Title:    report.Title,
+		Details:  report.Details,
+		Result:   result,
+		Reporter: "auto-release-pr",
+		Link:     link,
+	}
+	for _, value := range report.Data {
+		insights.Data = append(insights.Data, bitbucket.ReportData{Title: value.Title, Type: "TEXT", Value: value.Value})
+	}
+	_, err = client.CreateReport(b.Project, repoSlug, commit, report.Key, insights)
+	if err != nil {
+		return err
+	}
+	var annotations []bitbucket.Annotation
+	for _, finding := range report.Findings {
+		annotations = append(annotations, bitbucket.Annotation{
+			Path:     finding.Path,
+			Message:  finding.Message,
+			Severity: finding.Severity,
+			Type:     "BUG",
+		})
+	}
+	return client.SetAnnotations(b.Project, repoSlug, commit, report.Key, annotations)
+}
+
+func (b BitbucketServer) CloneURL(repoSlug string) string {
+	//https://bitbucket.dentsplysirona.com/scm/atopoc/dpns-gitops-prod.git
+	return b.SSH.CloneURL(repoSlug, b.Project, expandCloneUrl(b.CloneUrl, b.Project, repoSlug))
+}
+
+// Auth clones with the SSH key, the username and password, or with the HTTP
+// access token as a Bearer token
+func (b BitbucketServer) Auth(cloneUrl string) (transport.AuthMethod, error) {
+	if isSSHUrl(cloneUrl) {
+		return b.SSH.Auth(cloneUrl)
+	}
+	creds, err := b.Credentials.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return creds.GitAuth(""), nil
+}
@@ -0,0 +1,500 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+var conventionalCommit = regexp.MustCompile(`^([a-zA-Z]+)(?:\(([^)]*)\))?(!)?:\s*(.+)$`)
+
+// changelogGroups orders the conventional commit types in the changelog,
+// anything else ends up under Other changes
+var changelogGroups = []struct {
+	Type  string
+	Title string
+}{
+	{"feat", "Features"},
+	{"fix", "Bug fixes"},
+	{"perf", "Performance"},
+	{"refactor", "Refactoring"},
+	{"revert", "Reverts"},
+	{"docs", "Documentation"},
+	{"test", "Tests"},
+	{"build", "Build"},
+	{"ci", "CI"},
+	{"chore", "Chores"},
+	{"", "Other changes"},
+}
+
+// ChangelogEntry is one application commit between the deployed and new tag
+type ChangelogEntry struct {
+	Hash     string
+	Type     string
+	Scope    string
+	Subject  string
+	Message  string
+	Author   string
+	Breaking bool
+}
+
+// ChangelogGroup holds the entries of one conventional commit type
+type ChangelogGroup struct {
+	Title   string
+	Entries []ChangelogEntry
+}
+
+// ServiceChangelog is the commit range of a service's application repository
+// promoted by the release. Error is set instead of failing the release when
+// the range could not be read.
+type ServiceChangelog struct {
+	Service string
+	Source  string
+	From    string
+	To      string
+	Entries []ChangelogEntry
+	Error   string
+}
+
+// Groups sorts the entries by conventional commit type
+func (c ServiceChangelog) Groups() []ChangelogGroup {
+	var groups []ChangelogGroup
+	for _, g := range changelogGroups {
+		group := ChangelogGroup{Title: g.Title}
+		for _, entry := range c.Entries {
+			if entry.Type == g.Type || (g.Type == "" && !knownChangeType(entry.Type)) {
+				group.Entries = append(group.Entries, entry)
+			}
+		}
+		if len(group.Entries) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// Authors lists everyone with a commit in the range, in order of appearance
+func (c ServiceChangelog) Authors() []string {
+	var authors []string
+	seen := map[string]bool{}
+	for _, entry := range c.Entries {
+		if !seen[entry.Author] {
+			seen[entry.Author] = true
+			authors = append(authors, entry.Author)
+		}
+	}
+	return authors
+}
+
+func knownChangeType(t string) bool {
+	for _, g := range changelogGroups {
+		if g.Type != "" && g.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCommit splits a commit message into its conventional commit parts
+func parseCommit(c *object.Commit) ChangelogEntry {
+	entry := ChangelogEntry{
+		Hash:    c.Hash.String()[:7],
+		Message: c.Message,
+		Author:  c.Author.Name,
+	}
+	subject := strings.TrimSpace(strings.SplitN(c.Message, "\n", 2)[0])
+	entry.Subject = subject
+	if m := conventionalCommit.FindStringSubmatch(subject); m != nil {
+		entry.Type = strings.ToLower(m[1])
+		entry.Scope = m[2]
+		entry.Breaking = m[3] == "!"
+		entry.Subject = m[4]
+	}
+	if strings.Contains(c.Message, "BREAKING CHANGE") {
+		entry.Breaking = true
+	}
+	return entry
+}
+
+// AppSourceUrl is where the application source of a service lives, the
+// --app-source template wins over app.source in config.yaml
+func (s PrConfig) AppSourceUrl(release ServiceRelease) string {
+	if s.AppSource != "" {
+		return strings.ReplaceAll(s.AppSource, "{service}", release.Service)
+	}
+	return release.Source
+}
+
+// commitRange lists the commits reachable from to but not from from, newest
+// first. A shallow clone is only walked as far as it goes.
+func commitRange(r *git.Repository, from string, to string) ([]ChangelogEntry, error) {
+	toCommit, err := revisionCommit(r, to)
+	if err != nil {
+		return nil, err
+	}
+	fromCommit, err := revisionCommit(r, from)
+	if err != nil {
+		return nil, err
+	}
+	boundary, err := shallowParents(r)
+	if err != nil {
+		return nil, err
+	}
+	deployed, err := reachable(fromCommit, boundary)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ChangelogEntry
+	err = object.NewCommitPreorderIter(toCommit, deployed, boundary).ForEach(func(c *object.Commit) error {
+		entries = append(entries, parseCommit(c))
+		return nil
+	})
+	return entries, err
+}
+
+// revisionCommit resolves revision to its commit
+func revisionCommit(r *git.Repository, revision string) (*object.Commit, error) {
+	hash, err := r.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %s", revision, err)
+	}
+	return r.CommitObject(*hash)
+}
+
+// shallowParents are the parents a shallow clone is cut off from, walks
+// ignoring them stay inside the clone. Deepening keeps the earlier shallow
+// commits listed, only the parents still missing count.
+func shallowParents(r *git.Repository) ([]plumbing.Hash, error) {
+	shallow, err := r.Storer.Shallow()
+	if err != nil {
+		return nil, err
+	}
+	var parents []plumbing.Hash
+	for _, hash := range shallow {
+		commit, err := r.CommitObject(hash)
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range commit.ParentHashes {
+			if r.Storer.HasEncodedObject(parent) != nil {
+				parents = append(parents, parent)
+			}
+		}
+	}
+	return parents, nil
+}
+
+// reachable is the set of commits reachable from c short of boundary
+func reachable(c *object.Commit, boundary []plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	seen := map[plumbing.Hash]bool{}
+	err := object.NewCommitPreorderIter(c, nil, boundary).ForEach(func(c *object.Commit) error {
+		seen[c.Hash] = true
+		return nil
+	})
+	return seen, err
+}
+
+// appSource is a shallow clone of the default branch of an application
+// repository
+type appSource struct {
+	url   string
+	r     *git.Repository
+	auth  transport.AuthMethod
+	depth int
+}
+
+// appSourceAuth authenticates clones of an application repository. The
+// provider credentials are only sent to the provider's own host, sources
+// elsewhere are cloned anonymously.
+func (s PrConfig) appSourceAuth(source string) (transport.AuthMethod, error) {
+	gitops, err := transport.NewEndpoint(s.Provider.CloneURL(s.SetLocalRepoSlug()))
+	if err != nil {
+		return nil, err
+	}
+	app, err := transport.NewEndpoint(source)
+	if err != nil {
+		return nil, err
+	}
+	if app.Host != gitops.Host {
+		logger.Printf("%s is not on %s, cloning it without credentials", source, orNone(gitops.Host))
+		return nil, nil
+	}
+	return s.Provider.Auth(source)
+}
+
+// cloneAppSource clones the default branch of an application repository as
+// shallow as FetchDepth allows, deepen fetches what a changelog needs
+func (s PrConfig) cloneAppSource(url string) (*appSource, error) {
+	auth, err := s.appSourceAuth(url)
+	if err != nil {
+		return nil, err
+	}
+	branch, err := defaultBranch(url, auth)
+	if err != nil {
+		return nil, err
+	}
+	source := &appSource{url: url, auth: auth, depth: s.FetchDepth}
+	logger.Printf("cloning application source %s %s at depth %d", url, branch.Short(), source.depth)
+	source.r, err = git.Clone(memory.NewStorage(), nil, &git.CloneOptions{
+		URL:           url,
+		Auth:          auth,
+		ReferenceName: branch,
+		SingleBranch:  true,
+		Depth:         source.depth,
+		Tags:          git.NoTags,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cloning %s: %s", url, err)
+	}
+	return source, nil
+}
+
+// defaultBranch reads the branch HEAD points to on the remote, a single
+// branch clone of HEAD would take it for master
+func defaultBranch(url string, auth transport.AuthMethod) (plumbing.ReferenceName, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{url}})
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", fmt.Errorf("listing the branches of %s: %s", url, err)
+	}
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target(), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no default branch", url)
+}
+
+// deepen fetches more history, the way CloneRelease does, until the clone
+// has from and to and where they branched off. Commits still missing in the
+// full history are left for commitRange to report.
+func (a *appSource) deepen(from string, to string) error {
+	for !sharesHistory(a.r, from, to) && a.depth != 0 && a.depth != fullHistoryDepth {
+		a.depth = nextFetchDepth(a.depth)
+		logger.Printf("%s..%s not in the clone of %s yet, deepening to %d", from, to, a.url, a.depth)
+		err := a.r.Fetch(&git.FetchOptions{Auth: a.auth, Depth: a.depth, Tags: git.NoTags})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return fmt.Errorf("fetching %s: %s", a.url, err)
+		}
+	}
+	return nil
+}
+
+// sharesHistory says whether the revisions from and to are both in r with a
+// common ancestor, without walking past the end of a shallow clone
+func sharesHistory(r *git.Repository, from string, to string) bool {
+	fromCommit, err := revisionCommit(r, from)
+	if err != nil {
+		return false
+	}
+	toCommit, err := revisionCommit(r, to)
+	if err != nil {
+		return false
+	}
+	boundary, err := shallowParents(r)
+	if err != nil {
+		return false
+	}
+	deployed, err := reachable(fromCommit, boundary)
+	if err != nil {
+		return false
+	}
+	shared := false
+	err = object.NewCommitPreorderIter(toCommit, nil, boundary).ForEach(func(c *object.Commit) error {
+		if deployed[c.Hash] {
+			shared = true
+			return storer.ErrStop
+		}
+		return nil
+	})
+	return err == nil && shared
+}
+
+// CollectChangelogs clones the application repository of every service the
+// release changes and reads the commits between the deployed and new hash.
+// Failures are recorded on the changelog, a missing changelog should not stop
+// a release. Issue keys are read from the same commits.
+func (s PrConfig) CollectChangelogs() {
+	if s.Release == nil || !(s.Changelog || s.IssueKeys) {
+		return
+	}
+	repos := map[string]*appSource{}
+	s.Release.Changelogs = nil
+	for _, release := range s.Release.Services {
+		if release.Unchanged() {
+			continue
+		}
+		_, from := SplitImageTag(release.OldImageTag)
+		_, to := SplitImageTag(release.NewImageTag)
+		changelog := ServiceChangelog{
+			Service: release.Service,
+			Source:  s.AppSourceUrl(release),
+			From:    from,
+			To:      to,
+		}
+		switch {
+		case changelog.Source == "":
+			changelog.Error = "no application source configured"
+		case from == "" || to == "":
+			changelog.Error = "no previous deployment to compare with"
+		default:
+			source, ok := repos[changelog.Source]
+			var err error
+			if !ok {
+				source, err = s.cloneAppSource(changelog.Source)
+				if err == nil {
+					repos[changelog.Source] = source
+				}
+			}
+			if err == nil {
+				err = source.deepen(from, to)
+			}
+			if err == nil {
+				changelog.Entries, err = commitRange(source.r, from, to)
+			}
+			if err != nil {
+				changelog.Error = err.Error()
+			}
+		}
+		if changelog.Error != "" {
+			logger.Printf("changelog of %s: %s", release.Service, changelog.Error)
+		}
+		s.Release.Changelogs = append(s.Release.Changelogs, changelog)
+	}
+}
+
+// RenderChangelog writes the changelogs as markdown, headings start at level
+func RenderChangelog(changelogs []ServiceChangelog, level int) string {
+	var b strings.Builder
+	heading := strings.Repeat("#", level)
+	for _, c := range changelogs {
+		fmt.Fprintf(&b, "%s %s (%s..%s)\n\n", heading, c.Service, orNone(c.From), c.To)
+		if c.Error != "" {
+			fmt.Fprintf(&b, "Changelog unavailable: %s\n\n", c.Error)
+			continue
+		}
+		if len(c.Entries) == 0 {
+			b.WriteString("No commits.\n\n")
+			continue
+		}
+		for _, group := range c.Groups() {
+			fmt.Fprintf(&b, "%s# %s\n\n", heading, group.Title)
+			for _, entry := range group.Entries {
+				b.WriteString("- ")
+				if entry.Breaking {
+					b.WriteString("**BREAKING** ")
+				}
+				if entry.Scope != "" {
+					fmt.Fprintf(&b, "**%s:** ", entry.Scope)
+				}
+				fmt.Fprintf(&b, "%s (%s, %s)\n", entry.Subject, entry.Hash, entry.Author)
+			}
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "Authors: %s\n\n", strings.Join(c.Authors(), ", "))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// WriteChangelogFile puts the release's changelog at the top of
+// ChangelogFile in the gitops checkout and commits it. The section of the
+// release branch is replaced on later runs instead of added again.
+func (s PrConfig) WriteChangelogFile(r *git.Repository, wt *git.Worktree, fs billy.Filesystem) error {
+	if s.Release == nil || s.ChangelogFile == "" || len(s.Release.Changelogs) == 0 {
+		return nil
+	}
+	var existing string
+	f, err := fs.Open(s.ChangelogFile)
+	if err == nil {
+		var content []byte
+		content, err = ioutil.ReadAll(f)
+		f.Close()
+		existing = string(content)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// The section keeps the date it was first written on, a rerun changing
+	// nothing else leaves the file alone
+	header := fmt.Sprintf("## %s", s.SourceBranch)
+	heading := sectionHeading(existing, header)
+	if heading == "" {
+		heading = fmt.Sprintf("%s (%s)", header, time.Now().UTC().Format("2006-01-02"))
+	}
+	section := fmt.Sprintf("%s\n\n%s\n", heading, RenderChangelog(s.Release.Changelogs, 3))
+	content := replaceSection(existing, header, section)
+	if content == existing {
+		return nil
+	}
+	err = util.WriteFile(fs, s.ChangelogFile, []byte(content), 0644)
+	if err != nil {
+		return err
+	}
+	_, err = wt.Add(s.ChangelogFile)
+	if err != nil {
+		return err
+	}
+	logger.Println("updated changelog: ", s.ChangelogFile)
+	return s.Commit(r, wt, fmt.Sprintf("Update the %s changelog for %s", s.Environment(), s.SourceBranch))
+}
+
+// sectionHeading is the heading line of the "## " section starting with
+// header, empty when content has none
+func sectionHeading(content string, header string) string {
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == header || strings.HasPrefix(line, header+" ") {
+			return strings.TrimRight(line, "\r ")
+		}
+	}
+	return ""
+}
+
+// replaceSection swaps the "## " section starting with header for section, or
+// puts section first, below a leading "# " title if the file has one
+func replaceSection(content string, header string, section string) string {
+	lines := strings.SplitAfter(content, "\n")
+	start, end := -1, len(lines)
+	for i, line := range lines {
+		if start < 0 && (strings.TrimSpace(line) == header || strings.HasPrefix(line, header+" ")) {
+			start = i
+			continue
+		}
+		if start >= 0 && strings.HasPrefix(line, "## ") {
+			end = i
+			break
+		}
+	}
+	if start >= 0 {
+		rest := strings.Join(lines[end:], "")
+		if rest != "" {
+			section += "\n"
+		}
+		return strings.Join(lines[:start], "") + section + rest
+	}
+	if strings.HasPrefix(content, "# ") {
+		i := strings.Index(content, "\n")
+		if i < 0 {
+			return content + "\n\n" + section
+		}
+		return content[:i+1] + "\n" + section + "\n" + strings.TrimLeft(content[i+1:], "\n")
+	}
+	if content == "" {
+		return section
+	}
+	return section + "\n" + content
+}
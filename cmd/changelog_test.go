@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+func testChangelogConfig() PrConfig {
+	return PrConfig{
+		SourceBranch:  "release/1",
+		ChangelogFile: "CHANGELOG.md",
+		Author:        CommitIdentity{Name: "test", Email: "test@example.com"},
+		Release: &ReleaseReport{Changelogs: []ServiceChangelog{{
+			Service: "api",
+			From:    "1.0.0",
+			To:      "1.1.0",
+			Entries: []ChangelogEntry{{Hash: "abc1234", Type: "feat", Subject: "add orders"}},
+		}}},
+	}
+}
+
+// TestWriteChangelogFileKeepsDate checks a rerun with the same changes
+// leaves a section written on an earlier day alone
+func TestWriteChangelogFileKeepsDate(t *testing.T) {
+	r := testRepo(t, "pcoe", map[string]string{"api": "1.0.0"})
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testChangelogConfig()
+	existing := fmt.Sprintf("# Changelog\n\n## release/1 (2020-01-01)\n\n%s\n", RenderChangelog(s.Release.Changelogs, 3))
+	err = util.WriteFile(wt.Filesystem, s.ChangelogFile, []byte(existing), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.WriteChangelogFile(r, wt, wt.Filesystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := util.ReadFile(wt.Filesystem, s.ChangelogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != existing {
+		t.Errorf("changelog is\n%s\nwant it unchanged", content)
+	}
+	if len(s.Release.Commits) != 0 {
+		t.Errorf("commits are %v, want none", s.Release.Commits)
+	}
+}
+
+// TestWriteChangelogFileNewSection checks a new section is dated today and
+// committed
+func TestWriteChangelogFileNewSection(t *testing.T) {
+	r := testRepo(t, "pcoe", map[string]string{"api": "1.0.0"})
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := testChangelogConfig()
+
+	err = s.WriteChangelogFile(r, wt, wt.Filesystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := util.ReadFile(wt.Filesystem, s.ChangelogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	heading := fmt.Sprintf("## release/1 (%s)\n", time.Now().UTC().Format("2006-01-02"))
+	if !strings.HasPrefix(string(content), heading) {
+		t.Errorf("changelog is\n%s\nwant it to start with %q", content, heading)
+	}
+	if len(s.Release.Commits) != 1 {
+		t.Errorf("commits are %v, want one", s.Release.Commits)
+	}
+}
+
+// TestAppSourceAuth checks the provider credentials are only sent to
+// application repositories on the provider's host
+func TestAppSourceAuth(t *testing.T) {
+	provider := NewGitHub("acme", "https://github.example.com/api/v3", "", CredentialSource{Auth: authToken, Password: "github-token"}, SSHOptions{})
+	s := PrConfig{Provider: provider, StagingRepoSlug: "gitops"}
+	for source, authenticated := range map[string]bool{
+		"https://github.example.com/acme/api.git":    true,
+		"https://git.elsewhere.example/acme/api.git": false,
+	} {
+		auth, err := s.appSourceAuth(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (auth != nil) != authenticated {
+			t.Errorf("%s: auth is %v, want credentials %v", source, auth, authenticated)
+		}
+	}
+}
+
+// TestCommitRangeShallow checks the changelog of a clone holding only the
+// deployed commit and what came after is read without the older history
+func TestCommitRangeShallow(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is needed for the file:// transport")
+	}
+	dir := t.TempDir()
+	origin, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := origin.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes []string
+	for i := 1; i <= 6; i++ {
+		err = util.WriteFile(wt.Filesystem, "version", []byte(fmt.Sprintf("%d\n", i)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = wt.Add("version")
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := wt.Commit(fmt.Sprintf("feat: version %d", i), &git.CommitOptions{
+			Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash.String())
+	}
+
+	r, err := git.Clone(memory.NewStorage(), memfs.New(), &git.CloneOptions{URL: "file://" + dir, Depth: 3, Tags: git.NoTags})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sharesHistory(r, hashes[3], hashes[5]) {
+		t.Fatalf("the clone at depth 3 has no merge base of %s and %s", hashes[3], hashes[5])
+	}
+	entries, err := commitRange(r, hashes[3], hashes[5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var subjects []string
+	for _, entry := range entries {
+		subjects = append(subjects, entry.Subject)
+	}
+	if strings.Join(subjects, ", ") != "version 6, version 5" {
+		t.Errorf("changelog is %v, want versions 6 and 5", subjects)
+	}
+}
@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const defaultCommitTemplate = `Promote {{ .Service }} {{ .NewImageTag }} to {{ .Environment }}
+
+{{ .Service }}: {{ or .OldImageTag "none" }} -> {{ .NewImageTag }} ({{ .Change }})`
+
+// CommitIdentity is the name and email of a commit author or committer, the
+// git config user when empty
+type CommitIdentity struct {
+	Name  string
+	Email string
+}
+
+func (i CommitIdentity) signature() *object.Signature {
+	if i.Name == "" && i.Email == "" {
+		return nil
+	}
+	return &object.Signature{Name: i.Name, Email: i.Email, When: time.Now()}
+}
+
+// CommitData is what the commit message template is executed with
+type CommitData struct {
+	Environment  string
+	Product      string
+	SourceBranch string
+	TargetBranch string
+	Service      string
+	OldImageTag  string
+	NewImageTag  string
+	Change       string
+	CI           CIMetadata
+}
+
+// VersionCommitMessage renders the commit message of an image tag update
+func (s PrConfig) VersionCommitMessage(service string, oldTag string, newTag string) (string, error) {
+	data := CommitData{
+		Environment:  s.Environment(),
+		Product:      s.Product,
+		SourceBranch: s.SourceBranch,
+		TargetBranch: s.TargetBranch,
+		Service:      service,
+		OldImageTag:  oldTag,
+		NewImageTag:  newTag,
+		Change:       VersionChange(oldTag, newTag),
+		CI:           LoadCIMetadata(),
+	}
+	text := s.CommitTemplate
+	if text == "" {
+		text = defaultCommitTemplate
+	}
+	return executeTemplate("commit message", text, data)
+}
+
+// commitTrailers say which build promoted the change, who started it and
+// which commit of the pipeline it ran from, when the CI sets them
+func commitTrailers(ci CIMetadata) []string {
+	var trailers []string
+	for _, trailer := range []struct{ key, value string }{
+		{"Build-Url", ci.BuildUrl},
+		{"Promoted-By", ci.BuildUser},
+		{"Source-Commit", ci.GitCommit},
+	} {
+		if trailer.value != "" {
+			trailers = append(trailers, fmt.Sprintf("%s: %s", trailer.key, trailer.value))
+		}
+	}
+	return trailers
+}
+
+// Commit commits the worktree as the configured author and committer with the
+// CI trailers added to message, signs the commit when a signing format is set
+// and records it for VerifyCommits
+func (s PrConfig) Commit(r *git.Repository, wt *git.Worktree, message string) error {
+	message = strings.TrimSpace(message)
+	if trailers := commitTrailers(LoadCIMetadata()); len(trailers) > 0 {
+		message = fmt.Sprintf("%s\n\n%s", message, strings.Join(trailers, "\n"))
+	}
+	options := &git.CommitOptions{
+		Author:    s.Author.signature(),
+		Committer: s.Committer.signature(),
+	}
+	if s.Signing.Format == signOpenPGP {
+		key, err := s.Signing.openPGPKey()
+		if err != nil {
+			return err
+		}
+		options.SignKey = key
+	}
+	hash, err := wt.Commit(message+"\n", options)
+	if err != nil {
+		return err
+	}
+	if s.Signing.Format == signSSH {
+		hash, err = s.Signing.sshSignHead(r, hash)
+		if err != nil {
+			return err
+		}
+	}
+	if s.Release != nil {
+		s.Release.Commits = append(s.Release.Commits, hash)
+	}
+	return nil
+}
@@ -2,8 +2,6 @@ package cmd
 
 import (
 	"bufio"
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/memfs"
@@ -12,12 +10,10 @@ import (
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/storage/memory"
-	http2 "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
 	"gopkg.in/yaml.v3"
 	"io"
 	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -106,6 +102,23 @@ func CleanWorkTree(wt *git.Worktree, fileStrings []string) error {
 	return nil
 }
 
+// ResolveBranches defaults the target branch to the repository's default branch
+// and the start point to the target branch
+func (s PrConfig) ResolveBranches() (PrConfig, error) {
+	if s.TargetBranch == "" {
+		defaultBranch, err := s.Provider.DefaultBranch(s.SetLocalRepoSlug())
+		if err != nil {
+			return s, fmt.Errorf("looking up the default branch of %s: %s", s.SetLocalRepoSlug(), err)
+		}
+		s.TargetBranch = defaultBranch
+	}
+	if s.StartPoint == "" {
+		s.StartPoint = s.TargetBranch
+	}
+	logger.Printf("targeting %s, starting from %s", s.TargetBranch, s.StartPoint)
+	return s, nil
+}
+
 func (s PrConfig) IsStaging() bool {
 	if s.ProdRepoSlug == "" {
 		return true
@@ -114,57 +127,92 @@ func (s PrConfig) IsStaging() bool {
 	}
 }
 
-func IncreaseFetchDepth(r *git.Repository, f git.FetchOptions, depth int) error {
-	depth += 10
-	f.Depth = depth
-	err := r.Fetch(&f)
+func (s PrConfig) OpenPullRequest() {
+
+	localRepoSlug := s.SetLocalRepoSlug()
+
+	title, err := s.PullRequestTitle()
+	if err != nil {
+		log.Fatal(err)
+	}
+	description, err := s.PullRequestDescription()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pr := PullRequest{
+		Title:        title,
+		Description:  description,
+		SourceBranch: s.SourceBranch,
+		TargetBranch: s.TargetBranch,
+		Reviewers:    s.ReleaseReviewers(),
+	}
+
+	err = s.Provider.OpenPullRequest(localRepoSlug, pr)
 	if err != nil {
-		if depth == 200 {
-			return fmt.Errorf("Depth is at 200 - this is the limit: %s", err)
+		logger.Println(localRepoSlug)
+		log.Fatal(err)
+	}
+	logger.Println("Pull request was opened.")
+
+	annotate := !s.IsStaging() && (s.JiraComment || s.JiraTransition != "")
+	decline := s.StalePolicy == stalePolicyDecline && s.Release != nil && len(s.Release.Stale) > 0
+	if annotate || decline {
+		opened, err := s.Provider.FindPullRequest(localRepoSlug, s.SourceBranch, s.TargetBranch)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if opened == nil {
+			opened = &pr
+		}
+		if annotate {
+			s.AnnotateIssues(opened.Url)
+		}
+		err = s.DeclineStalePullRequests(*opened)
+		if err != nil {
+			log.Fatal(err)
 		}
-		_ = IncreaseFetchDepth(r, f, depth)
 	}
-	return nil
+
+	return
 }
 
-func (s PrConfig) OpenPullRequest() {
+func (s PrConfig) UpdatePullRequest() {
 
 	localRepoSlug := s.SetLocalRepoSlug()
 
-	body := PullRequestPayload{
-		FromRef: struct {
-			ID   string `json:"id"`
-			Type string `json:"type"`
-		}{fmt.Sprintf("refs/heads/%s", s.SourceBranch), "BRANCH"},
-		ToRef: struct {
-			ID   string `json:"id"`
-			Type string `json:"type"`
-		}{"refs/heads/main", "BRANCH"},
-		Title:       fmt.Sprintf("Candidate release to staging: %s", s.SourceBranch),
-		Description: fmt.Sprintf("Candidate release to staging: %s", s.SourceBranch),
-	}
-
-	jsonBody, _ := json.Marshal(body)
-	httpClient := &http.Client{}
-
-	req, err := http.NewRequest("POST", fmt.Sprintf("https://%s/projects/%s/repos/%s/pull-requests", bbBaseUrl, s.BBProject, localRepoSlug), bytes.NewBuffer(jsonBody))
-	req.Header.Set("Authorization", fmt.Sprintf("Basic %s", bitBucketCredentialString))
-	req.Header.Set("X-Atlassian-Token", "no-check")
-	req.Header.Set("Content-Type", "application/json")
-	resp, err := httpClient.Do(req)
+	pr, err := s.Provider.FindPullRequest(localRepoSlug, s.SourceBranch, s.TargetBranch)
 	if err != nil {
 		log.Fatal(err)
 	}
-	if resp.StatusCode > 201 {
-		err := fmt.Errorf("wrong status code when trying to create PR: %d", resp.StatusCode)
-		logger.Println(localRepoSlug)
+	if pr == nil {
+		log.Fatalf("pull request for %s disappeared before it could be updated", s.SourceBranch)
+	}
+
+	pr.Title, err = s.PullRequestTitle()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pr.Description, err = s.PullRequestDescription()
+	if err != nil {
+		log.Fatal(err)
+	}
+	pr.Reviewers = s.ReleaseReviewers()
+	err = s.Provider.UpdatePullRequest(localRepoSlug, *pr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	logger.Printf("Pull request %d was updated.", pr.ID)
+
+	if s.CommentOnUpdate {
+		err = s.Provider.CommentPullRequest(localRepoSlug, *pr, s.UpdateComment())
 		if err != nil {
 			log.Fatal(err)
 		}
-	} else {
-		logger.Println("Pull request was opened.")
 	}
-
+	err = s.DeclineStalePullRequests(*pr)
+	if err != nil {
+		log.Fatal(err)
+	}
 	return
 }
 
@@ -176,29 +224,7 @@ func (s PrConfig) CheckoutBranch(exists bool) {
 	if !exists {
 		logger.Printf("trying to create branch: %s\n", s.SourceBranch)
 
-		body := CreateBranchPayload{
-			Message:    "Release Branch",
-			Name:       s.SourceBranch,
-			StartPoint: "main",
-		}
-
-		jsonBody, err := json.Marshal(body)
-		httpClient := &http.Client{}
-		req, err := http.NewRequest("POST", fmt.Sprintf("https://%s/projects/%s/repos/%s/branches", bbBaseUrl, s.BBProject, localRepoSlug), bytes.NewBuffer(jsonBody))
-		if err != nil {
-			log.Fatal(err)
-		}
-		req.Header.Set("Authorization", fmt.Sprintf("Basic %s", bitBucketCredentialString))
-		req.Header.Set("X-Atlassian-Token", "no-check")
-		req.Header.Set("Content-Type", "application/json")
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			log.Fatal(err)
-		}
-		if resp.StatusCode > 201 {
-			log.Fatalf("wrong status code when trying to create branch: %d", resp.StatusCode)
-		}
-		_, err = ioutil.ReadAll(resp.Body)
+		err := s.Provider.CreateBranch(localRepoSlug, s.SourceBranch, s.StartPoint)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -206,34 +232,20 @@ func (s PrConfig) CheckoutBranch(exists bool) {
 	logger.Printf("trying to clone repo: %s\n", localRepoSlug)
 	fs := memfs.New()
 	//Clone the repo into memory
-	r, err := git.Clone(memory.NewStorage(), fs, &git.CloneOptions{
-		//https://bitbucket.dentsplysirona.com/scm/atopoc/dpns-gitops-prod.git
-		URL:   fmt.Sprintf("https://%s/scm/%s/%s.git", repoBaseUrl, s.BBProject, localRepoSlug),
-		Auth:  &http2.BasicAuth{Username: os.Getenv(username), Password: os.Getenv(password)},
-		Depth: 10,
-		//ReferenceName: plumbing.ReferenceName(s.SourceBranch),
-	})
-
+	r, err := s.CloneRelease(localRepoSlug, fs)
 	if err != nil {
 		log.Fatal(err)
 	}
-	logger.Println("fetching...")
-	f := git.FetchOptions{
-		RefSpecs: []config.RefSpec{"refs/*:refs/*"},
-		Auth:     &http2.BasicAuth{Username: os.Getenv(username), Password: os.Getenv(password)},
-		Depth:    10,
-	}
-	err = r.Fetch(&f)
-	if err != nil {
-		logger.Println("Error fetching... Starting recursive function with increasing fetch depth...")
-		err = IncreaseFetchDepth(r, f, f.Depth)
-	}
 	logger.Println("fetching done!")
 	//Check out the working tree
 	wt, err := r.Worktree()
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = s.LoadPullRequestTemplate(fs)
+	if err != nil {
+		log.Fatal(err)
+	}
 	if s.IsStaging() {
 
 		var foundLocal bool
@@ -261,32 +273,61 @@ func (s PrConfig) CheckoutBranch(exists bool) {
 		}
 
 		s.UpdateVersionFiles(r, wt, fs, nil)
+		err = s.DescribeRelease(r, wt, fs)
+		if err != nil {
+			log.Fatal(err)
+		}
 		s.CommitAndPush(r, wt)
 		return
 	} else {
 		logger.Println("checkout staging repo and branch and copy everything from staging to prod.")
 		fs1 := memfs.New()
-		//Clone the repo into memory
-		r1, err := git.Clone(memory.NewStorage(), fs1, &git.CloneOptions{
-			//https://bitbucket.dentsplysirona.com/scm/atopoc/dpns-gitops-prod.git
-			URL:   fmt.Sprintf("https://%s/scm/%s/%s.git", repoBaseUrl, s.BBProject, s.StagingRepoSlug),
-			Auth:  &http2.BasicAuth{Username: os.Getenv(username), Password: os.Getenv(password)},
-			Depth: 10,
-		})
+		stagingUrl := s.Provider.CloneURL(s.StagingRepoSlug)
+		stagingAuth, err := s.Provider.Auth(stagingUrl)
 		if err != nil {
 			log.Fatal(err)
 		}
-		err = r1.Fetch(&f)
+		//Clone the repo into memory, only the files of its default branch are read
+		_, err = git.Clone(memory.NewStorage(), fs1, &git.CloneOptions{
+			URL:   stagingUrl,
+			Auth:  stagingAuth,
+			Depth: 1,
+			Tags:  git.NoTags,
+		})
 		if err != nil {
-			logger.Println("error fetching from second repo (staging)... Starting recursive function with increasing fetch depth...")
-			err = IncreaseFetchDepth(r1, f, f.Depth)
+			log.Fatal(err)
 		}
 		logger.Println("Fetching done!")
 		s.UpdateVersionFiles(r, wt, fs, fs1)
+		err = s.DescribeRelease(r, wt, fs)
+		if err != nil {
+			log.Fatal(err)
+		}
 		s.CommitAndPush(r, wt)
 	}
 }
 
+// DescribeRelease gathers what the pull request says about the release once
+// the release branch is updated: the image tag changes, stale pull requests,
+// changelogs, issue keys and reviewers
+func (s PrConfig) DescribeRelease(r *git.Repository, wt *git.Worktree, fs billy.Filesystem) error {
+	err := s.CollectReleaseChanges(r, s.TargetBranch)
+	if err != nil {
+		return err
+	}
+	err = s.FindStalePullRequests(r)
+	if err != nil {
+		return err
+	}
+	s.CollectChangelogs()
+	s.CollectIssueKeys()
+	err = s.WriteChangelogFile(r, wt, fs)
+	if err != nil {
+		return err
+	}
+	return s.ResolveReviewers(fs)
+}
+
 func (s PrConfig) UpdateManifests(r *git.Repository, wt *git.Worktree, fs billy.Filesystem, fs1 billy.Filesystem, wg *sync.WaitGroup, service string) {
 	defer wg.Done()
 	var authoritativeManifestPath string
@@ -382,8 +423,8 @@ func (s PrConfig) UpdateVersionFiles(r *git.Repository, wt *git.Worktree, fs bil
 		appConfig := AppConfigFile{}
 
 		//Set up my branch options so I can create or checkout the branch
-		//Switch to main to get updated test semver.yaml
-		sbt := plumbing.ReferenceName("refs/heads/main")
+		//Switch to the start point to get updated test semver.yaml
+		sbt := plumbing.NewBranchReferenceName(s.StartPoint)
 		s.SwitchBranch(r, wt, sbt)
 
 		authoritativePath := fmt.Sprintf("%s/services/%s/images/latest/.semver.yaml", s.Product, v)
@@ -391,11 +432,10 @@ func (s PrConfig) UpdateVersionFiles(r *git.Repository, wt *git.Worktree, fs bil
 
 		//Probably a more optimal way than if else this stuff
 		//Load the source file
+		dPath = s.AppConfigPath(v)
 		if s.IsStaging() {
-			dPath = fmt.Sprintf("%s/.argocd/staging/%s/config.yaml", s.Product, v)
 			myVersionData = ReadFile(versionFile, authoritativePath, dPath, fs)
 		} else {
-			dPath = fmt.Sprintf("%s/.argocd/production/r2/%s/config.yaml", s.Product, v)
 			myVersionData = ReadFile(versionFile, authoritativePath, dPath, fs1)
 		}
 
@@ -434,7 +474,9 @@ func (s PrConfig) UpdateVersionFiles(r *git.Repository, wt *git.Worktree, fs bil
 		logger.Println("the app Config data: ", appConfig)
 
 		//Update Version Value for Staging!!!!
+		oldImageTag := appConfig.App.ImageTag
 		appConfig.App.ImageTag = fmt.Sprintf("%s-%s", versionFile.Release, versionFile.CommitHash)
+		s.recordUpdate(v, oldImageTag, appConfig.App.ImageTag)
 
 		//Rewrite Yaml
 		cfg, err := fs.OpenFile(dPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
@@ -470,11 +512,7 @@ func (s PrConfig) UpdateVersionFiles(r *git.Repository, wt *git.Worktree, fs bil
 		//More changes for prod/staging
 		var myAdd plumbing.Hash
 
-		if s.IsStaging() {
-			myAdd, err = wt.Add(fmt.Sprintf("%s/.argocd/staging/%s/config.yaml", s.Product, v))
-		} else {
-			myAdd, err = wt.Add(fmt.Sprintf("%s/.argocd/production/r2/%s/config.yaml", s.Product, v))
-		}
+		myAdd, err = wt.Add(s.AppConfigPath(v))
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -484,7 +522,11 @@ func (s PrConfig) UpdateVersionFiles(r *git.Repository, wt *git.Worktree, fs bil
 			logger.Println("Worktree status for: ", k, v.Extra, v.Worktree)
 		}
 
-		_, err = wt.Commit("Auto commit version update for release", &git.CommitOptions{})
+		message, err := s.VersionCommitMessage(v, oldImageTag, appConfig.App.ImageTag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = s.Commit(r, wt, message)
 		if err != nil {
 			log.Fatal("An error occurred committing", err)
 		}
@@ -498,69 +540,47 @@ func (s PrConfig) UpdateVersionFiles(r *git.Repository, wt *git.Worktree, fs bil
 
 func (s PrConfig) CommitAndPush(r *git.Repository, wt *git.Worktree) {
 
+	err := s.VerifyCommits(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth, err := s.Provider.Auth(s.Provider.CloneURL(s.SetLocalRepoSlug()))
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Only the release branch is pushed, the target, start point and other
+	// release branches are fetched into refs/heads too, shallow
 	pushOptions := git.PushOptions{
 		RemoteName: "origin",
-		//RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/remotes/origin/%s", s.SourceBranch, s.SourceBranch))},
-		Auth: &http2.BasicAuth{Username: os.Getenv(username), Password: os.Getenv(password)},
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("refs/heads/%s:refs/heads/%s", s.SourceBranch, s.SourceBranch))},
+		Auth:       auth,
 	}
-	err := r.Push(&pushOptions)
+	err = r.Push(&pushOptions)
 	if err != nil {
 		log.Fatal(err)
 	}
 	logger.Println("Commit and push complete")
+	// The release is already pushed, so a failed report still opens the
+	// pull request and fails the run after
+	err = s.ReportCommit(r)
+	if err != nil && s.Release != nil {
+		logger.Printf("%s, failing once the pull request is open", err)
+		s.Release.ReportError = err
+	} else if err != nil {
+		log.Fatal(err)
+	}
 	return
 }
 
 func (s PrConfig) CheckBranchExists() (bool, error) {
-
 	logger.Println("checking for branch")
-	client := &http.Client{}
-
-	localRepoSlug := s.SetLocalRepoSlug()
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/projects/%s/repos/%s/branches?filterText=%s", bbBaseUrl, s.BBProject, localRepoSlug, s.SourceBranch), nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Basic %s", bitBucketCredentialString))
-	response, err := client.Do(req)
-	if err != nil {
-		log.Fatal(err)
-	}
-	data, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
-	logger.Println(response.StatusCode, "\n", string(data))
-	if len(string(data)) > 61 {
-		return true, nil
-	} else if len(string(data)) == 61 {
-		return false, nil
-	} else {
-		return false, fmt.Errorf("seems like the length of the response didn't match the predicted outcomes, it was: %d", len(string(data)))
-	}
+	return s.Provider.BranchExists(s.SetLocalRepoSlug(), s.SourceBranch)
 }
 
 func (s PrConfig) CheckPullRequestExists() (bool, error) {
 	logger.Println("Checking for pull request")
-	httpClient := &http.Client{}
-
-	localRepoSlug := s.SetLocalRepoSlug()
-
-	req, err := http.NewRequest("GET", fmt.Sprintf("https://%s/projects/%s/repos/%s/pull-requests?filterText=%s", bbBaseUrl, s.BBProject, localRepoSlug, s.SourceBranch), nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Basic %s", bitBucketCredentialString))
-	response, err := httpClient.Do(req)
-	if err != nil {
-		log.Fatal(err)
-	}
-	data, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Fatal(err)
-	}
-	if len(string(data)) > 61 {
-		return true, nil
-	} else if len(string(data)) == 61 {
-		return false, nil
-	} else {
-		return false, fmt.Errorf("seems like the length of the response didn't match the predicted outcomes, it was: %d", len(string(data)))
-	}
+	pr, err := s.Provider.FindPullRequest(s.SetLocalRepoSlug(), s.SourceBranch, s.TargetBranch)
+	return pr != nil, err
 }
 
 func PrepRelease(c Config) {
@@ -579,9 +599,14 @@ func PrepRelease(c Config) {
 		log.Fatal(err)
 	}
 	if prExists {
-		return
+		logger.Println("updating pull request...")
+		c.UpdatePullRequest()
 	} else {
 		logger.Println("opening pull request...")
 		c.OpenPullRequest()
 	}
+	err = c.ReportError()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -2,25 +2,327 @@ package cmd
 
 import (
 	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/utils"
-	"encoding/base64"
 	"fmt"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 //https://bitbucket.dentsplysirona.com/scm/atopoc/cirrus-poc-gitops.git
 const (
-	bbBaseUrl   = "bitbucket.dentsplysirona.com/rest/api/1.0"
-	repoBaseUrl = "bitbucket.dentsplysirona.com/scm"
-	username    = "USERNAME"
-	password    = "PASSWORD"
+	defaultBitbucketHost     = "bitbucket.dentsplysirona.com"
+	defaultBitbucketApiPath  = "/rest/api/1.0"
+	defaultBitbucketCloneUrl = "{scheme}://{host}/scm/{project}/{repo}.git"
+	defaultBitbucketSSHUrl   = "ssh://git@{host}:7999/{project}/{repo}.git"
+	defaultConfigFile        = ".auto-release-pr.yaml"
+	envPrefix                = "AUTO_RELEASE_PR_"
+	username                 = "USERNAME"
+	password                 = "PASSWORD"
+	jiraUsername             = "JIRA_USERNAME"
+	jiraToken                = "JIRA_TOKEN"
 	//username = "TEMPUSER"
 	//password = "BBTOKEN"
 )
 
-var bitBucketCredentialString string = base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", os.Getenv(username), os.Getenv(password))))
-
 var debugOn utils.Logger = utils.Logger{Debug: false}
 var fatalError utils.Error = utils.Error{Fatal: true}
 
 var logger = log.New(os.Stdout, "logger: ", log.Lshortfile)
+
+// Settings are the options shared by every command. Each one can be set in the
+// config file, overridden by an AUTO_RELEASE_PR_<NAME> environment variable and
+// finally by the flag of the same name.
+type Settings struct {
+	Provider string `yaml:"provider"`
+	Scheme   string `yaml:"scheme"`
+	Host     string `yaml:"host"`
+	ApiPath  string `yaml:"api-path"`
+	ApiUrl   string `yaml:"api-url"`
+	CloneUrl string `yaml:"clone-url"`
+	JiraUrl  string `yaml:"jira-url"`
+
+	// JiraUsernameEnv, JiraTokenEnv and JiraSecretsDir are where the Jira
+	// credentials are looked up, along with the git credential helpers,
+	// credentials and netrc files for the Jira host
+	JiraUsernameEnv string `yaml:"jira-username-env"`
+	JiraTokenEnv    string `yaml:"jira-token-env"`
+	JiraSecretsDir  string `yaml:"jira-secrets-dir"`
+
+	// Auth is basic or token. Username and Password are given outright,
+	// otherwise they are looked up in the UsernameEnv and PasswordEnv
+	// variables, the git credential helpers, credentials and netrc files and
+	// finally the username and password files in SecretsDir. A
+	// CredentialCommand replaces the whole chain.
+	Auth        string `yaml:"auth"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	UsernameEnv string `yaml:"username-env"`
+	PasswordEnv string `yaml:"password-env"`
+	SecretsDir  string `yaml:"secrets-dir"`
+
+	CredentialCommand string `yaml:"credential-command"`
+
+	// SSHRepos is a comma separated list of the repositories cloned, fetched
+	// and pushed over SSH, * for all of them
+	SSHRepos            string `yaml:"ssh-repos"`
+	SSHCloneUrl         string `yaml:"ssh-clone-url"`
+	SSHUser             string `yaml:"ssh-user"`
+	SSHKey              string `yaml:"ssh-key"`
+	SSHKeyPassphraseEnv string `yaml:"ssh-key-passphrase-env"`
+	KnownHosts          string `yaml:"known-hosts"`
+
+	// Sign is the commit signature format, openpgp or ssh, SigningKey the
+	// key file and SigningKeyPassphraseEnv the variable holding its passphrase
+	Sign                    string `yaml:"sign"`
+	SigningKey              string `yaml:"signing-key"`
+	SigningKeyPassphraseEnv string `yaml:"signing-key-passphrase-env"`
+
+	// AuthorName, AuthorEmail, CommitterName and CommitterEmail set the
+	// identity of the release commits and CommitTemplate their message
+	AuthorName     string `yaml:"author-name"`
+	AuthorEmail    string `yaml:"author-email"`
+	CommitterName  string `yaml:"committer-name"`
+	CommitterEmail string `yaml:"committer-email"`
+	CommitTemplate string `yaml:"commit-template"`
+
+	// FetchDepth is the initial clone depth of the gitops repository, 0 for
+	// the full history
+	FetchDepth string `yaml:"fetch-depth"`
+
+	// DefaultReviewers is a comma separated list of users and @@groups added
+	// to every release pull request, StagingReviewers and ProductionReviewers
+	// to the ones of that environment only
+	DefaultReviewers    string `yaml:"default-reviewers"`
+	StagingReviewers    string `yaml:"staging-reviewers"`
+	ProductionReviewers string `yaml:"production-reviewers"`
+}
+
+// fields maps the flag, config file key and environment variable suffix of
+// each setting to where it is stored
+func (s *Settings) fields() map[string]*string {
+	return map[string]*string{
+		"provider":  &s.Provider,
+		"scheme":    &s.Scheme,
+		"host":      &s.Host,
+		"api-path":  &s.ApiPath,
+		"api-url":   &s.ApiUrl,
+		"clone-url": &s.CloneUrl,
+		"jira-url":  &s.JiraUrl,
+
+		"jira-username-env": &s.JiraUsernameEnv,
+		"jira-token-env":    &s.JiraTokenEnv,
+		"jira-secrets-dir":  &s.JiraSecretsDir,
+
+		"auth":         &s.Auth,
+		"username-env": &s.UsernameEnv,
+		"password-env": &s.PasswordEnv,
+		"username":     &s.Username,
+		"password":     &s.Password,
+		"secrets-dir":  &s.SecretsDir,
+
+		"credential-command": &s.CredentialCommand,
+
+		"ssh-repos":              &s.SSHRepos,
+		"ssh-clone-url":          &s.SSHCloneUrl,
+		"ssh-user":               &s.SSHUser,
+		"ssh-key":                &s.SSHKey,
+		"ssh-key-passphrase-env": &s.SSHKeyPassphraseEnv,
+		"known-hosts":            &s.KnownHosts,
+
+		"sign":                       &s.Sign,
+		"signing-key":                &s.SigningKey,
+		"signing-key-passphrase-env": &s.SigningKeyPassphraseEnv,
+
+		"author-name":     &s.AuthorName,
+		"author-email":    &s.AuthorEmail,
+		"committer-name":  &s.CommitterName,
+		"committer-email": &s.CommitterEmail,
+		"commit-template": &s.CommitTemplate,
+
+		"fetch-depth": &s.FetchDepth,
+
+		"default-reviewers":    &s.DefaultReviewers,
+		"staging-reviewers":    &s.StagingReviewers,
+		"production-reviewers": &s.ProductionReviewers,
+	}
+}
+
+func envName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_"))
+}
+
+// LoadSettings resolves the settings for cmd from defaults, the config file,
+// the environment and the command line, in that order
+func LoadSettings(cmd *cobra.Command) (Settings, error) {
+	settings := Settings{
+		Provider: providerBitbucketServer,
+		Scheme:   "https",
+		Host:     defaultBitbucketHost,
+		ApiPath:  defaultBitbucketApiPath,
+
+		UsernameEnv: username,
+		PasswordEnv: password,
+		SecretsDir:  defaultSecretsDir,
+
+		JiraUsernameEnv: jiraUsername,
+		JiraTokenEnv:    jiraToken,
+		JiraSecretsDir:  defaultJiraSecretsDir,
+
+		SSHUser:             defaultSSHUser,
+		SSHKeyPassphraseEnv: defaultSSHPassphraseEnv,
+
+		SigningKeyPassphraseEnv: defaultSigningPassphraseEnv,
+
+		FetchDepth: strconv.Itoa(defaultFetchDepth),
+	}
+
+	configFile, _ := cmd.Flags().GetString("config")
+	if configFile == "" {
+		configFile = os.Getenv(envName("config"))
+	}
+	explicit := configFile != ""
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err == nil {
+			configFile = filepath.Join(home, defaultConfigFile)
+		}
+	}
+	if configFile != "" {
+		data, err := ioutil.ReadFile(configFile)
+		if err == nil {
+			err = yaml.Unmarshal(data, &settings)
+			if err != nil {
+				return settings, fmt.Errorf("reading config file %s: %s", configFile, err)
+			}
+		} else if explicit || !os.IsNotExist(err) {
+			return settings, err
+		}
+	}
+
+	for key, value := range settings.fields() {
+		if env, ok := os.LookupEnv(envName(key)); ok {
+			*value = env
+		}
+		if cmd.Flags().Changed(key) {
+			*value, _ = cmd.Flags().GetString(key)
+		}
+	}
+	if settings.Auth != "" && settings.Auth != authBasic && settings.Auth != authToken {
+		return settings, fmt.Errorf("unknown auth %q, expected %s or %s", settings.Auth, authBasic, authToken)
+	}
+	err := validSigningFormat(settings.Sign)
+	if err != nil {
+		return settings, err
+	}
+	if settings.Sign != "" && settings.SigningKey == "" {
+		return settings, fmt.Errorf("signing %s commits needs --signing-key", settings.Sign)
+	}
+	if settings.CredentialCommand != "" && strings.TrimSpace(settings.CredentialCommand) == "" {
+		return settings, fmt.Errorf("--credential-command is blank")
+	}
+	if depth, err := strconv.Atoi(settings.FetchDepth); err != nil || depth < 0 {
+		return settings, fmt.Errorf("fetch depth %q is not a number of commits", settings.FetchDepth)
+	}
+	if (settings.AuthorName == "") != (settings.AuthorEmail == "") {
+		return settings, fmt.Errorf("the commit author needs both --author-name and --author-email")
+	}
+	if (settings.CommitterName == "") != (settings.CommitterEmail == "") {
+		return settings, fmt.Errorf("the committer needs both --committer-name and --committer-email")
+	}
+	return settings, nil
+}
+
+// Reviewers adds the reviewers of the environment and then of a command to the
+// default reviewers
+func (s Settings) Reviewers(environment string, reviewers []string) []string {
+	all := splitList(s.DefaultReviewers)
+	switch environment {
+	case environmentStaging:
+		all = append(all, splitList(s.StagingReviewers)...)
+	case environmentProduction:
+		all = append(all, splitList(s.ProductionReviewers)...)
+	}
+	return append(all, splitList(strings.Join(reviewers, ","))...)
+}
+
+// splitList splits a comma separated setting, dropping empty entries
+func splitList(list string) []string {
+	var all []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			all = append(all, item)
+		}
+	}
+	return all
+}
+
+// JiraCredentials is where the Jira credentials come from, kept apart from the
+// provider credentials
+func (s Settings) JiraCredentials() CredentialSource {
+	return CredentialSource{UsernameEnv: s.JiraUsernameEnv, PasswordEnv: s.JiraTokenEnv, SecretsDir: s.JiraSecretsDir}.ForUrls(s.JiraUrl)
+}
+
+// SigningOptions says how the release commits are signed
+func (s Settings) SigningOptions() SigningOptions {
+	return SigningOptions{Format: s.Sign, KeyFile: s.SigningKey, PassphraseEnv: s.SigningKeyPassphraseEnv}
+}
+
+// Depth is the initial clone depth, validated by LoadSettings
+func (s Settings) Depth() int {
+	depth, _ := strconv.Atoi(s.FetchDepth)
+	return depth
+}
+
+// Author is the configured commit author, if any
+func (s Settings) Author() CommitIdentity {
+	return CommitIdentity{Name: s.AuthorName, Email: s.AuthorEmail}
+}
+
+// Committer is the configured committer, the author when unset
+func (s Settings) Committer() CommitIdentity {
+	return CommitIdentity{Name: s.CommitterName, Email: s.CommitterEmail}
+}
+
+// ProviderOptions builds the provider options for project. For Bitbucket Server
+// the API url is derived from scheme, host and API path unless given outright.
+func (s Settings) ProviderOptions(project string) ProviderOptions {
+	opts := ProviderOptions{
+		Project:  project,
+		ApiUrl:   s.ApiUrl,
+		CloneUrl: s.CloneUrl,
+		Scheme:   s.Scheme,
+		Host:     s.Host,
+		Credentials: CredentialSource{
+			Auth:        s.Auth,
+			User:        s.Username,
+			Password:    s.Password,
+			UsernameEnv: s.UsernameEnv,
+			PasswordEnv: s.PasswordEnv,
+			SecretsDir:  s.SecretsDir,
+			Command:     s.CredentialCommand,
+		},
+		SSH: SSHOptions{
+			Repos:         splitList(s.SSHRepos),
+			CloneUrl:      s.SSHCloneUrl,
+			User:          s.SSHUser,
+			KeyFile:       s.SSHKey,
+			PassphraseEnv: s.SSHKeyPassphraseEnv,
+			KnownHosts:    s.KnownHosts,
+		},
+	}
+	if (s.Provider == providerBitbucketServer || s.Provider == "") && opts.ApiUrl == "" {
+		opts.ApiUrl = fmt.Sprintf("%s://%s%s", s.Scheme, s.Host, s.ApiPath)
+	}
+	if (s.Provider == providerBitbucketServer || s.Provider == "") && opts.CloneUrl == "" {
+		opts.CloneUrl = defaultBitbucketCloneUrl
+	}
+	if (s.Provider == providerBitbucketServer || s.Provider == "") && opts.SSH.CloneUrl == "" {
+		opts.SSH.CloneUrl = defaultBitbucketSSHUrl
+	}
+	return opts
+}
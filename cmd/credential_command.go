@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// credentialExpiryMargin is how long before their expiry credentials are
+	// refreshed
+	credentialExpiryMargin   = time.Minute
+	credentialCommandTimeout = time.Minute
+)
+
+// credentialRequest is written to the stdin of a credential command
+type credentialRequest struct {
+	Auth string   `json:"auth,omitempty"`
+	Urls []string `json:"urls"`
+}
+
+// credentialResponse is what a credential command prints on stdout. Expiry is
+// an RFC 3339 time, empty for tokens that don't expire.
+type credentialResponse struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+	Expiry   string `json:"expiry"`
+}
+
+// runCommand runs the credential command, e.g. a script fetching a short-lived
+// token from Vault. The command line is run by sh, as git runs its credential
+// helpers, so it may quote paths with spaces or pass arguments. It reads a
+// credentialRequest on stdin and prints a credentialResponse, its stderr goes
+// to ours so it can log.
+func (c CredentialSource) runCommand() (Credentials, error) {
+	creds := Credentials{Auth: c.Auth}
+	if strings.TrimSpace(c.Command) == "" {
+		return creds, errors.New("the credential command is blank")
+	}
+	request, err := json.Marshal(credentialRequest{Auth: c.Auth, Urls: c.Urls})
+	if err != nil {
+		return creds, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), credentialCommandTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.Command)
+	cmd.Stdin = bytes.NewReader(request)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return creds, fmt.Errorf("credential command %s: %s", c.Command, err)
+	}
+
+	var response credentialResponse
+	err = json.Unmarshal(out, &response)
+	if err != nil {
+		return creds, fmt.Errorf("credential command %s printed no credential JSON: %s", c.Command, err)
+	}
+	if response.Token == "" {
+		return creds, fmt.Errorf("credential command %s returned no token", c.Command)
+	}
+	creds.Username, creds.Secret = response.Username, response.Token
+	creds.Source = fmt.Sprintf("credential command %s", c.Command)
+	if response.Expiry != "" {
+		creds.Expiry, err = time.Parse(time.RFC3339, response.Expiry)
+		if err != nil {
+			return creds, fmt.Errorf("credential command %s returned expiry %q: %s", c.Command, response.Expiry, err)
+		}
+	}
+	return creds, nil
+}
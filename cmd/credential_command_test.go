@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCredentialCommandPathWithSpaces checks the command line is run by a
+// shell, so a quoted executable path may hold spaces
+func TestCredentialCommandPathWithSpaces(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "credential scripts")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "vault token")
+	err = ioutil.WriteFile(script, []byte("#!/bin/sh\ncat >/dev/null\necho '{\"username\": \"svc-release\", \"token\": \"'\"$1\"'\"}'\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := CredentialSource{Command: `"` + script + `" short-lived`}
+	creds, err := source.runCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Username != "svc-release" || creds.Secret != "short-lived" {
+		t.Errorf("credentials are %s / %s, want svc-release / short-lived", creds.Username, creds.Secret)
+	}
+}
+
+func TestCredentialCommandBlank(t *testing.T) {
+	_, err := CredentialSource{Command: "  "}.runCommand()
+	if err == nil {
+		t.Error("a blank credential command ran")
+	}
+
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(envName("credential-command"), " \t")
+	_, err = LoadSettings(rootCmd)
+	if err == nil || !strings.Contains(err.Error(), "credential-command is blank") {
+		t.Errorf("LoadSettings with a blank credential command returned %v", err)
+	}
+}
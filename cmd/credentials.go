@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/bitbucket"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// How the provider is authenticated against
+const (
+	authBasic = "basic"
+	authToken = "token"
+)
+
+// CredentialSource says where the provider credentials come from. They are
+// resolved when a request first needs them, from the first of these holding a
+// secret:
+//
+//   - User and Password, the --username and --password settings
+//   - the UsernameEnv and PasswordEnv variables
+//   - git credential fill, i.e. the configured git credential helpers
+//   - ~/.git-credentials and $XDG_CONFIG_HOME/git/credentials
+//   - $NETRC or ~/.netrc
+//   - username and password files in SecretsDir, e.g. a mounted secret
+//
+// The stores are looked up by the host of each of Urls in turn. A missing
+// secret fails with an error naming the places searched instead of an
+// anonymous request being rejected. With a Command the chain is skipped and
+// the credentials come from the command alone, see runCommand.
+type CredentialSource struct {
+	// Auth is basic, token or empty to use basic auth when a username is
+	// found and token auth otherwise
+	Auth        string
+	User        string
+	Password    string
+	UsernameEnv string
+	PasswordEnv string
+	SecretsDir  string
+	Command     string
+	Urls        []string
+}
+
+// Credentials are resolved credentials. Secret is the password, app password
+// or access token, Source says where it was found. Short-lived tokens have an
+// Expiry, the zero time otherwise.
+type Credentials struct {
+	Auth     string
+	Username string
+	Secret   string
+	Source   string
+	Expiry   time.Time
+}
+
+// expiring says whether the credentials run out within the margin, so they
+// are refreshed before a request could fail halfway through a push
+func (c Credentials) expiring() bool {
+	return !c.Expiry.IsZero() && time.Now().Add(credentialExpiryMargin).After(c.Expiry)
+}
+
+type resolved struct {
+	creds Credentials
+	err   error
+}
+
+// credentialCache keeps what each source resolved to, so credential helpers
+// and commands are asked once per run, or once per token lifetime
+var credentialCache = struct {
+	sync.Mutex
+	sources map[string]resolved
+}{sources: map[string]resolved{}}
+
+// ForUrls returns the source looking up its stores by the hosts of urls
+func (c CredentialSource) ForUrls(urls ...string) CredentialSource {
+	c.Urls = append(append([]string(nil), c.Urls...), urls...)
+	return c
+}
+
+// Resolve walks the credential chain, see CredentialSource
+func (c CredentialSource) Resolve() (Credentials, error) {
+	key := fmt.Sprintf("%#v", c)
+	credentialCache.Lock()
+	defer credentialCache.Unlock()
+	found, ok := credentialCache.sources[key]
+	if ok && !found.creds.expiring() {
+		return found.creds, found.err
+	}
+	creds, err := c.resolve()
+	if err == nil && ok {
+		logger.Printf("refreshed credentials from %s, expiring at %s", creds.Source, creds.Expiry.Format(time.RFC3339))
+	} else if err == nil {
+		logger.Printf("using %s auth credentials from %s", creds.Auth, creds.Source)
+	}
+	credentialCache.sources[key] = resolved{creds, err}
+	return creds, err
+}
+
+func (c CredentialSource) resolve() (Credentials, error) {
+	usernameEnv, passwordEnv := c.UsernameEnv, c.PasswordEnv
+	if usernameEnv == "" {
+		usernameEnv = username
+	}
+	if passwordEnv == "" {
+		passwordEnv = password
+	}
+	creds := Credentials{Auth: c.Auth}
+	searched := fmt.Sprintf("--password, %s, a git credential helper, ~/.git-credentials, ~/.netrc or %s", passwordEnv, c.secretsDir())
+	if c.Command != "" {
+		var err error
+		creds, err = c.runCommand()
+		if err != nil {
+			return creds, err
+		}
+		searched = fmt.Sprintf("the output of %s", c.Command)
+	} else {
+		creds = c.findInChain(usernameEnv, passwordEnv)
+	}
+	if creds.Auth == "" {
+		creds.Auth = authToken
+		if creds.Username != "" {
+			creds.Auth = authBasic
+		}
+	}
+	switch creds.Auth {
+	case authBasic:
+		if creds.Secret == "" {
+			return creds, fmt.Errorf("basic auth needs a password for %s in %s", c.hosts(), searched)
+		}
+		if creds.Username == "" {
+			return creds, fmt.Errorf("basic auth needs a username, set --username or %s", usernameEnv)
+		}
+	case authToken:
+		if creds.Secret == "" {
+			return creds, fmt.Errorf("token auth needs an access token for %s in %s", c.hosts(), searched)
+		}
+	default:
+		return creds, fmt.Errorf("unknown auth %q, expected %s or %s", creds.Auth, authBasic, authToken)
+	}
+	return creds, nil
+}
+
+// findInChain returns the secret of the first store holding one. A username
+// without a secret is kept in case a later store only holds the secret.
+func (c CredentialSource) findInChain(usernameEnv string, passwordEnv string) Credentials {
+	creds := Credentials{Auth: c.Auth}
+	for _, store := range c.stores(usernameEnv, passwordEnv) {
+		user, secret := store.find()
+		if secret == "" {
+			if creds.Username == "" {
+				creds.Username = user
+			}
+			continue
+		}
+		if user != "" {
+			creds.Username = user
+		}
+		creds.Secret, creds.Source = secret, store.name
+		break
+	}
+	return creds
+}
+
+// Username is the user the provider authenticates as, if known
+func (c CredentialSource) Username() string {
+	creds, _ := c.Resolve()
+	return creds.Username
+}
+
+// Header is the Authorization header value for REST requests
+func (c Credentials) Header() string {
+	if c.Auth == authToken {
+		return fmt.Sprintf("Bearer %s", c.Secret)
+	}
+	return fmt.Sprintf("Basic %s", base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", c.Username, c.Secret))))
+}
+
+// RestAuth authenticates REST requests with a Bearer or Basic header
+func (c Credentials) RestAuth() bitbucket.Authenticator {
+	if c.Auth == authToken {
+		return &githttp.TokenAuth{Token: c.Secret}
+	}
+	return &githttp.BasicAuth{Username: c.Username, Password: c.Secret}
+}
+
+// GitAuth authenticates clones and pushes. Hosts that take access tokens as
+// the password of a fixed user name pass it as tokenUser, otherwise the token
+// is sent as a Bearer header.
+func (c Credentials) GitAuth(tokenUser string) transport.AuthMethod {
+	switch {
+	case c.Auth == authBasic:
+		return &githttp.BasicAuth{Username: c.Username, Password: c.Secret}
+	case tokenUser != "":
+		return &githttp.BasicAuth{Username: tokenUser, Password: c.Secret}
+	default:
+		return &githttp.TokenAuth{Token: c.Secret}
+	}
+}
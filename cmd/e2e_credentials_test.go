@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestE2ECredentialChain checks a missing token is reported by the variable
+// it was expected in, then finds it in the credential helper, the
+// .git-credentials file and the secrets dir in turn. Resolved credentials are
+// cached per source, so each lookup uses its own secrets dir.
+func TestE2ECredentialChain(t *testing.T) {
+	env := newE2E(t)
+	lookup := func(secretsDir string) error {
+		provider, err := NewProvider(providerBitbucketServer, ProviderOptions{
+			Project:     e2eProject,
+			ApiUrl:      env.server.ApiUrl(),
+			Credentials: CredentialSource{Auth: authToken, PasswordEnv: "E2E_MISSING_TOKEN", SecretsDir: filepath.Join(env.home, secretsDir)},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = provider.DefaultBranch(e2eStagingRepo)
+		return err
+	}
+
+	err := lookup("missing")
+	if err == nil || !strings.Contains(err.Error(), "E2E_MISSING_TOKEN") {
+		t.Fatalf("expected an error naming E2E_MISSING_TOKEN, got %v", err)
+	}
+	api, err := url.Parse(env.server.ApiUrl())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := fmt.Sprintf("http://e2e:%s@%s\n", e2eToken, api.Host)
+
+	err = ioutil.WriteFile(filepath.Join(env.home, e2eHelperCredentials), []byte(stored), 0600)
+	if err == nil {
+		err = lookup("helper")
+	}
+	if err != nil {
+		t.Fatalf("credential helper: %s", err)
+	}
+	err = os.Remove(filepath.Join(env.home, e2eHelperCredentials))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(env.home, ".git-credentials"), []byte(stored), 0600)
+	if err == nil {
+		err = lookup("git-credentials")
+	}
+	if err != nil {
+		t.Fatalf(".git-credentials: %s", err)
+	}
+	err = os.Remove(filepath.Join(env.home, ".git-credentials"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.MkdirAll(filepath.Join(env.home, "secrets"), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(env.home, "secrets", "password"), []byte(e2eToken+"\n"), 0600)
+	}
+	if err == nil {
+		err = lookup("secrets")
+	}
+	if err != nil {
+		t.Fatalf("secrets dir: %s", err)
+	}
+}
+
+// e2eCredentialScript is a credential command printing the e2e token with the
+// expiry given as its second argument, counting its runs in the file given as
+// the first
+const e2eCredentialScript = `#!/bin/sh
+cat > /dev/null
+echo run >> "$1"
+printf '{"username": "e2e", "token": "%s", "expiry": "%s"}\n' "` + e2eToken + `" "$2"
+`
+
+// TestE2ECredentialCommand checks a token from a credential command is reused
+// while it is valid and fetched again once it is about to expire
+func TestE2ECredentialCommand(t *testing.T) {
+	env := newE2E(t)
+	script := filepath.Join(env.home, "credential-command")
+	err := ioutil.WriteFile(script, []byte(e2eCredentialScript), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expect := range []struct {
+		name   string
+		expiry time.Duration
+		runs   int
+	}{
+		{"long-lived", time.Hour, 1},
+		{"expiring", credentialExpiryMargin / 2, 2},
+	} {
+		counter := filepath.Join(env.home, expect.name+"-runs")
+		provider, err := NewProvider(providerBitbucketServer, ProviderOptions{
+			Project: e2eProject,
+			ApiUrl:  env.server.ApiUrl(),
+			Credentials: CredentialSource{
+				Auth:    authToken,
+				Command: fmt.Sprintf("%s %s %s", script, counter, time.Now().Add(expect.expiry).UTC().Format(time.RFC3339)),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			_, err = provider.DefaultBranch(e2eStagingRepo)
+			if err != nil {
+				t.Fatalf("%s credential command: %s", expect.name, err)
+			}
+		}
+		data, err := ioutil.ReadFile(counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runs := strings.Count(string(data), "run"); runs != expect.runs {
+			t.Errorf("%s credential command ran %d times, expected %d", expect.name, runs, expect.runs)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/bitbucket/fake"
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+// TestE2EUnrelatedHistory checks cloning a release branch that shares no
+// history with its target fails with an error saying so once the full
+// history is fetched
+func TestE2EUnrelatedHistory(t *testing.T) {
+	env := newE2E(t)
+	const repo = "gitops-unrelated"
+	path := env.server.RepoPath(e2eProject, repo)
+	err := fake.SeedRepository(path, "main", map[string]string{"README.md": "main\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(env.dir, "orphan.git")
+	err = fake.SeedRepository(orphan, "orphan", map[string]string{"README.md": "orphan\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("git", "-C", path, "fetch", orphan, "orphan:orphan").CombinedOutput()
+	if err != nil {
+		t.Fatalf("git fetch: %s: %s", err, out)
+	}
+	release := PrConfig{StagingRepoSlug: repo, SourceBranch: "orphan", TargetBranch: "main", Provider: env.provider(t, SSHOptions{}), FetchDepth: 1}
+	_, err = release.CloneRelease(repo, memfs.New())
+	if err == nil || !strings.Contains(err.Error(), "share no history") {
+		t.Errorf("expected unrelated branches to fail the clone, got %v", err)
+	}
+}
@@ -0,0 +1,366 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/bitbucket"
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/bitbucket/fake"
+	jirafake "bitbucket.dentsplysirona.com/atopoc/auto-release-pr/jira/fake"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/crypto/ssh"
+)
+
+// TestE2ERelease runs a staging release of one service, then of every
+// service, merges it and promotes it to prod over SSH
+func TestE2ERelease(t *testing.T) {
+	env := newE2E(t)
+	jiraServer := jirafake.NewServer(e2eJiraProject+"-1", e2eJiraProject+"-2", e2eJiraProject+"-3")
+	t.Cleanup(jiraServer.Close)
+	provider := env.provider(t, env.sshOptions(t))
+	openPGPSigning, sshSigning, allowedSigners := env.signing(t)
+
+	staging := PrConfig{
+		StagingRepoSlug: e2eStagingRepo,
+		BBProject:       e2eProject,
+		SourceBranch:    e2eBranch,
+		Product:         e2eProduct,
+		Services:        e2eServices[:1],
+		Provider:        provider,
+		CommentOnUpdate: true,
+		Release:         &ReleaseReport{},
+		Changelog:       true,
+		IssueKeys:       true,
+		IssueProjects:   []string{e2eJiraProject},
+		Reviewers:       []string{"release-manager"},
+		StalePolicy:     stalePolicyDecline,
+		Report:          true,
+		Signing:         openPGPSigning,
+		FetchDepth:      1,
+		Author:          CommitIdentity{Name: "Release Bot", Email: "release-bot@example.com"},
+		Committer:       CommitIdentity{Name: "Jenkins", Email: "jenkins@example.com"},
+	}
+	staging, err := staging.ResolveBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.openStale(t, provider)
+	PrepRelease(staging)
+
+	// the second run should update the pull request
+	staging.Services = e2eServices
+	staging.Release = &ReleaseReport{}
+	PrepRelease(staging)
+
+	for _, service := range e2eServices {
+		env.expectFile(t, e2eStagingRepo, e2eBranch, fmt.Sprintf("%s/.argocd/staging/%s/config.yaml", e2eProduct, service), "image_tag: "+env.tags[service].New)
+		env.expectFile(t, e2eStagingRepo, e2eBranch, fmt.Sprintf("%s/services/%s/manifests/base/staging/deployment.yaml", e2eProduct, service), "replicas: 2")
+	}
+	env.expectPullRequests(t, e2eStagingRepo, "main", "Candidate release to staging: "+e2eBranch)
+	env.expectUpdated(t, e2eStagingRepo, e2eServices[len(e2eServices)-1])
+	env.expectDeclined(t, e2eStagingRepo)
+	env.expectReport(t, e2eStagingRepo, e2eBranch, strings.Join(e2eServices, ", "))
+	env.expectSigned(t, e2eStagingRepo, e2eBranch, openPGPSigning, allowedSigners)
+	last := e2eServices[len(e2eServices)-1]
+	env.expectCommits(t, e2eStagingRepo, e2eBranch, staging.Author, staging.Committer, fmt.Sprintf("Promote %s %s to staging\n\n%s: %s -> %s", last, env.tags[last].New, last, env.tags[last].Staging, env.tags[last].New))
+
+	env.merge(t, staging)
+
+	prod := staging
+	prod.ProdRepoSlug = e2eProdRepo
+	prod.Release = &ReleaseReport{}
+	prod.TargetBranch = ""
+	prod.StartPoint = ""
+	prod.ChangelogFile = e2eProduct + "/CHANGELOG.md"
+	prod.JiraUrl = jiraServer.URL
+	prod.JiraCredentials = CredentialSource{UsernameEnv: jiraUsername, PasswordEnv: jiraToken}.ForUrls(jiraServer.URL)
+	prod.JiraComment = true
+	prod.JiraTransition = "Released"
+	prod.Signing = sshSigning
+	prod.Committer = CommitIdentity{}
+	prod.CommitTemplate = "{{ .Service }} {{ .OldImageTag }} -> {{ .NewImageTag }} in {{ .Environment }} by {{ .CI.BuildUser }}"
+	prod, err = prod.ResolveBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	PrepRelease(prod)
+
+	for _, service := range e2eServices {
+		env.expectFile(t, e2eProdRepo, e2eBranch, fmt.Sprintf("%s/.argocd/production/r2/%s/config.yaml", e2eProduct, service), "image_tag: "+env.tags[service].New)
+		env.expectFile(t, e2eProdRepo, e2eBranch, prod.ChangelogFile, "- handle empty responses")
+		env.expectFile(t, e2eProdRepo, e2eBranch, fmt.Sprintf("%s/services/%s/manifests/base/deployment.yaml", e2eProduct, service), "replicas: 2")
+	}
+	env.expectPullRequests(t, e2eProdRepo, e2eProdDefault, "Promote "+e2eProduct+" to production")
+	env.expectReport(t, e2eProdRepo, e2eBranch, strings.Join(e2eServices, ", "))
+	env.expectSigned(t, e2eProdRepo, e2eBranch, sshSigning, allowedSigners)
+	env.expectCommits(t, e2eProdRepo, e2eBranch, prod.Author, prod.Author,
+		"Update the production changelog for "+e2eBranch,
+		fmt.Sprintf("%s %s -> %s in production by jenkins-user", last, env.tags[last].Prod, env.tags[last].New))
+	expectIssues(t, jiraServer, []string{"DEMO-2", "DEMO-3"}, []string{"DEMO-1"})
+}
+
+// sshOptions serves the remotes over SSH for a fresh deploy key and returns
+// the options cloning the prod repository with it
+func (env *e2eEnv) sshOptions(t *testing.T) SSHOptions {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(env.dir, "deploy_key")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := fake.NewSSHServer(filepath.Join(env.dir, "remotes"), authorized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	knownHosts := filepath.Join(env.dir, "known_hosts")
+	err = ioutil.WriteFile(knownHosts, []byte(server.KnownHosts()), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SSHOptions{
+		Repos:      []string{e2eProdRepo},
+		CloneUrl:   server.CloneUrl(),
+		KeyFile:    keyFile,
+		KnownHosts: knownHosts,
+	}
+}
+
+// openStale opens an older release pull request in the staging repository
+// rolling the first service back to its prod tag
+func (env *e2eEnv) openStale(t *testing.T, provider Provider) {
+	service := e2eServices[0]
+	source := "file://" + filepath.ToSlash(env.server.RepoPath(e2eAppProject, service))
+	_, err := fake.CommitFiles(env.server.RepoPath(e2eProject, e2eStagingRepo), e2eStaleBranch, "older release", map[string]string{
+		fmt.Sprintf("%s/.argocd/staging/%s/config.yaml", e2eProduct, service): e2eAppConfig(service, source, env.tags[service].Prod),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = provider.OpenPullRequest(e2eStagingRepo, PullRequest{
+		Title:        "Candidate release to staging: " + e2eStaleBranch,
+		SourceBranch: e2eStaleBranch,
+		TargetBranch: "main",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// releasePullRequest returns the first pull request from the e2e release
+// branch
+func (env *e2eEnv) releasePullRequest(repo string) bitbucket.PullRequest {
+	for _, pr := range env.server.PullRequests(e2eProject, repo) {
+		if pr.FromRef.ID == "refs/heads/"+e2eBranch {
+			return pr
+		}
+	}
+	return bitbucket.PullRequest{}
+}
+
+// expectPullRequests checks the e2e release branch has a single pull request
+// into target titled title
+func (env *e2eEnv) expectPullRequests(t *testing.T, repo string, target string, title string) {
+	t.Helper()
+	var prs []bitbucket.PullRequest
+	for _, pr := range env.server.PullRequests(e2eProject, repo) {
+		if pr.FromRef.ID == "refs/heads/"+e2eBranch {
+			prs = append(prs, pr)
+		}
+	}
+	if len(prs) != 1 {
+		t.Fatalf("%s: expected 1 pull request, found %d", repo, len(prs))
+	}
+	if pr := prs[0]; pr.ToRef.ID != "refs/heads/"+target {
+		t.Errorf("%s: unexpected pull request %s -> %s", repo, pr.FromRef.ID, pr.ToRef.ID)
+	} else if pr.Title != title {
+		t.Errorf("%s: expected pull request title %q, got %q", repo, title, pr.Title)
+	}
+}
+
+// expectUpdated checks the release pull request was rewritten to cover every
+// service and commented on with the service the latest run added
+func (env *e2eEnv) expectUpdated(t *testing.T, repo string, added string) {
+	t.Helper()
+	pr := env.releasePullRequest(repo)
+	if pr.Version == 0 {
+		t.Fatalf("%s: pull request %d was never updated", repo, pr.ID)
+	}
+	for _, service := range e2eServices {
+		for _, want := range []string{
+			fmt.Sprintf("| %s | %s | %s | minor |", service, env.tags[service].Staging, env.tags[service].New),
+			fmt.Sprintf("**%s:** add health endpoint", service),
+			"- DEMO-3 (api, web)",
+		} {
+			if !strings.Contains(pr.Description, want) {
+				t.Errorf("%s: expected %q in the pull request description\n%s", repo, want, pr.Description)
+			}
+		}
+	}
+	var reviewers []string
+	for _, reviewer := range pr.Reviewers {
+		reviewers = append(reviewers, reviewer.User.Name)
+	}
+	if strings.Join(reviewers, ",") != "release-manager,alice,carol,bob" {
+		t.Errorf("%s: expected the default, file and group reviewers without the author, got %v", repo, reviewers)
+	}
+	comments := env.server.Comments(e2eProject, repo, pr.ID)
+	if len(comments) != 1 || !strings.Contains(comments[0].Text, added) {
+		t.Errorf("%s: expected one comment mentioning %s, got %v", repo, added, comments)
+	}
+}
+
+// expectDeclined checks the older release pull request was declined with a
+// comment linking the one superseding it
+func (env *e2eEnv) expectDeclined(t *testing.T, repo string) {
+	t.Helper()
+	release := env.releasePullRequest(repo)
+	var stale *bitbucket.PullRequest
+	prs := env.server.PullRequests(e2eProject, repo)
+	for i := range prs {
+		if prs[i].FromRef.ID == "refs/heads/"+e2eStaleBranch {
+			stale = &prs[i]
+		}
+	}
+	if stale == nil {
+		t.Fatalf("%s: expected a pull request from %s", repo, e2eStaleBranch)
+	}
+	if stale.State != "DECLINED" {
+		t.Errorf("%s: expected pull request %d from %s to be declined, it is %s", repo, stale.ID, e2eStaleBranch, stale.State)
+	}
+	comments := env.server.Comments(e2eProject, repo, stale.ID)
+	if len(comments) != 1 || !strings.Contains(comments[0].Text, release.Url()) {
+		t.Errorf("%s: expected one comment linking %s, got %v", repo, release.Url(), comments)
+	}
+}
+
+// merge walks the merge command through a pull request that waits for an
+// approval, is blocked by a needs work review and is finally merged
+func (env *e2eEnv) merge(t *testing.T, release PrConfig) {
+	t.Helper()
+	env.server.RequiredApprovals = 1
+	merge := MergeConfig{PrConfig: release, PollInterval: time.Millisecond}
+	id := env.releasePullRequest(e2eStagingRepo).ID
+	for _, step := range []struct {
+		review func(project string, repo string, id int, user string)
+		want   int
+	}{
+		{nil, exitTimedOut},
+		{env.server.NeedsWork, exitBlocked},
+		{env.server.Approve, exitMerged},
+	} {
+		if step.review != nil {
+			step.review(e2eProject, e2eStagingRepo, id, "bob")
+		}
+		code, err := merge.Merge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != step.want {
+			t.Fatalf("merge: expected exit code %d, got %d", step.want, code)
+		}
+	}
+	if pr := env.releasePullRequest(e2eStagingRepo); pr.State != "MERGED" {
+		t.Fatalf("merge: pull request %d is %s", pr.ID, pr.State)
+	}
+}
+
+// expectReport checks the tip of branch carries a passing build status and
+// report listing the promoted services
+func (env *e2eEnv) expectReport(t *testing.T, repo string, branch string, promoted string) {
+	t.Helper()
+	commit, err := fake.Head(env.server.RepoPath(e2eProject, repo), branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := env.server.BuildStatuses(commit)
+	if len(statuses) != 1 || statuses[0].Key != reportKey || statuses[0].State != bitbucket.BuildSuccessful {
+		t.Errorf("%s: expected a successful %s build status on %s, got %v", repo, reportKey, commit, statuses)
+	}
+	report, annotations := env.server.Report(e2eProject, repo, commit, reportKey)
+	if report == nil || report.Result != bitbucket.ReportPass {
+		t.Fatalf("%s: expected a passing %s report on %s, got %v", repo, reportKey, commit, report)
+	}
+	for _, data := range report.Data {
+		if data.Title == "Services promoted" && data.Value != promoted {
+			t.Errorf("%s: expected %s promoted, the report says %v", repo, promoted, data.Value)
+		}
+	}
+	if len(annotations) != 0 {
+		t.Errorf("%s: expected no findings, got %v", repo, annotations)
+	}
+}
+
+// expectCommits checks the last commits of branch, newest first, were made
+// by the configured identities with messages starting with subjects and
+// ending in the CI trailers
+func (env *e2eEnv) expectCommits(t *testing.T, repo string, branch string, author CommitIdentity, committer CommitIdentity, subjects ...string) {
+	t.Helper()
+	r, err := git.PlainOpen(env.server.RepoPath(e2eProject, repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := r.CommitObject(ref.Hash())
+	trailers := "\n\nBuild-Url: " + e2eBuildUrl + "\nPromoted-By: jenkins-user\nSource-Commit: 0123abc\n"
+	for i, subject := range subjects {
+		if i > 0 && err == nil {
+			commit, err = commit.Parent(0)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit.Author.Name != author.Name || commit.Author.Email != author.Email {
+			t.Errorf("%s: expected author %v, got %v", repo, author, commit.Author)
+		}
+		if commit.Committer.Name != committer.Name || commit.Committer.Email != committer.Email {
+			t.Errorf("%s: expected committer %v, got %v", repo, committer, commit.Committer)
+		}
+		if !strings.HasPrefix(commit.Message, subject) || !strings.HasSuffix(commit.Message, trailers) {
+			t.Errorf("%s: expected a message starting with %q and ending in the CI trailers, got %q", repo, subject, commit.Message)
+		}
+	}
+}
+
+// expectIssues checks the prod release commented on and transitioned only
+// the issues it promotes
+func expectIssues(t *testing.T, server *jirafake.Server, promoted []string, untouched []string) {
+	t.Helper()
+	for _, key := range promoted {
+		comments := server.Comments(key)
+		if len(comments) != 1 || !strings.Contains(comments[0].Body, "/pull-requests/") {
+			t.Errorf("%s: expected one comment linking the pull request, got %v", key, comments)
+		}
+		if server.Status(key) != "Released" {
+			t.Errorf("%s: expected status Released, got %s", key, server.Status(key))
+		}
+	}
+	for _, key := range untouched {
+		if len(server.Comments(key)) != 0 || server.Status(key) != "Open" {
+			t.Errorf("%s: should not have been annotated", key)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/bitbucket/fake"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/crypto/ssh"
+)
+
+// signing writes an OpenPGP and an SSH signing key, the staging release is
+// signed with the first and the prod release with the second. The allowed
+// signers file lets git verify-commit check the SSH signatures.
+func (env *e2eEnv) signing(t *testing.T) (SigningOptions, SigningOptions, string) {
+	entity, err := openpgp.NewEntity(fake.Signature.Name, "e2e", fake.Signature.Email, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var armored bytes.Buffer
+	w, err := armor.Encode(&armored, openpgp.PrivateKeyType, nil)
+	if err == nil {
+		err = entity.SerializePrivate(w, nil)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	openPGPKey := filepath.Join(env.dir, "signing.asc")
+	err = ioutil.WriteFile(openPGPKey, armored.Bytes(), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey := filepath.Join(env.dir, "signing_key")
+	err = ioutil.WriteFile(sshKey, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedSigners := filepath.Join(env.dir, "allowed_signers")
+	err = ioutil.WriteFile(allowedSigners, []byte(fake.Signature.Email+" "+string(ssh.MarshalAuthorizedKey(signer))), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return SigningOptions{Format: signOpenPGP, KeyFile: openPGPKey}, SigningOptions{Format: signSSH, KeyFile: sshKey}, allowedSigners
+}
+
+// expectSigned checks the tip of branch was pushed with a valid signature.
+// SSH signatures are checked by git verify-commit when ssh-keygen is around to
+// do so.
+func (env *e2eEnv) expectSigned(t *testing.T, repo string, branch string, signing SigningOptions, allowedSigners string) {
+	t.Helper()
+	path := env.server.RepoPath(e2eProject, repo)
+	head, err := fake.Head(path, branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exec.LookPath("ssh-keygen"); err == nil && signing.Format == signSSH {
+		out, err := exec.Command("git", "-C", path, "-c", "gpg.ssh.allowedSignersFile="+allowedSigners, "verify-commit", head).CombinedOutput()
+		if err != nil {
+			t.Errorf("%s: git verify-commit %s: %s: %s", repo, head, err, out)
+		}
+		return
+	}
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := PrConfig{Signing: signing, Release: &ReleaseReport{Commits: []plumbing.Hash{plumbing.NewHash(head)}}}
+	err = signed.VerifyCommits(r)
+	if err != nil {
+		t.Errorf("%s: %s", repo, err)
+	}
+}
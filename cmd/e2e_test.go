@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/bitbucket/fake"
+)
+
+// The e2e tests run the flows against an in-process fake Bitbucket Server
+// and bare repositories in a temporary directory. They need no network, only
+// git on the PATH for the file:// transport.
+
+const (
+	e2eProject     = "GITOPS"
+	e2eAppProject  = "APPS"
+	e2eStagingRepo = "gitops-nonprod"
+	e2eProdRepo    = "gitops-prod"
+	e2eProduct     = "products/demo"
+	e2eBranch      = "release/e2e"
+	e2eStaleBranch = "release/old"
+	e2eProdDefault = "master"
+	e2eJiraProject = "DEMO"
+	e2eTokenEnv    = "E2E_TOKEN"
+	e2eToken       = "e2e-token"
+	e2eBuildUrl    = "https://jenkins.example.com/job/release/42/"
+	// e2eHelperCredentials is the file of the store credential helper
+	e2eHelperCredentials = "helper-credentials"
+)
+
+var e2eServices = []string{"api", "web"}
+
+// e2eTags are the image tags of a service deployed to each environment and
+// built last, all pointing at commits in the service's application repository
+type e2eTags struct {
+	Prod    string
+	Staging string
+	New     string
+}
+
+// e2eEnv is the seeded remotes of one e2e test and the fake Bitbucket
+// serving them
+type e2eEnv struct {
+	dir    string
+	home   string
+	server *fake.Server
+	tags   map[string]e2eTags
+}
+
+// newE2E isolates git from the user's configuration, seeds the application,
+// staging and prod gitops remotes and serves them
+func newE2E(t *testing.T) *e2eEnv {
+	if testing.Short() {
+		t.Skip("e2e test")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is needed for the file:// transport")
+	}
+	env := &e2eEnv{dir: t.TempDir()}
+	env.home = filepath.Join(env.dir, "home")
+	err := os.MkdirAll(env.home, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gitConfig := fmt.Sprintf("[user]\n\tname = %s\n\temail = %s\n[credential]\n\thelper = store --file %s\n", fake.Signature.Name, fake.Signature.Email, filepath.Join(env.home, e2eHelperCredentials))
+	err = ioutil.WriteFile(filepath.Join(env.home, ".gitconfig"), []byte(gitConfig), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{"HOME": env.home, "XDG_CONFIG_HOME": env.home, "GIT_CONFIG_NOSYSTEM": "1", username: "e2e", password: "e2e", e2eTokenEnv: e2eToken, jiraToken: "e2e", "BUILD_URL": e2eBuildUrl, "BUILD_USER_ID": "jenkins-user", "GIT_COMMIT": "0123abc"} {
+		t.Setenv(k, v)
+	}
+
+	remotes := filepath.Join(env.dir, "remotes")
+	env.tags = map[string]e2eTags{}
+	staging := map[string]string{}
+	prod := map[string]string{}
+	for _, service := range e2eServices {
+		app := filepath.Join(remotes, e2eAppProject, service+".git")
+		hashes := e2eSeedApp(t, app, service)
+		env.tags[service] = e2eTags{
+			Prod:    "1.0.0-" + hashes[0],
+			Staging: "1.1.0-" + hashes[1],
+			New:     "1.2.0-" + hashes[2],
+		}
+		source := "file://" + filepath.ToSlash(app)
+
+		base := fmt.Sprintf("%s/services/%s", e2eProduct, service)
+		staging[base+"/images/latest/.semver.yaml"] = fmt.Sprintf("alpha: 0\nbeta: 0\ncommit-hash: %s\nrc: 0\nrelease: 1.2.0\n", hashes[2])
+		staging[base+"/manifests/base/main/deployment.yaml"] = fmt.Sprintf("kind: Deployment\nname: %s\nreplicas: 2\n", service)
+		staging[base+"/manifests/base/staging/deployment.yaml"] = fmt.Sprintf("kind: Deployment\nname: %s\nreplicas: 1\n", service)
+		staging[fmt.Sprintf("%s/.argocd/staging/%s/config.yaml", e2eProduct, service)] = e2eAppConfig(service, source, env.tags[service].Staging)
+
+		prod[base+"/manifests/base/deployment.yaml"] = fmt.Sprintf("kind: Deployment\nname: %s\nreplicas: 0\n", service)
+		prod[fmt.Sprintf("%s/.argocd/production/r2/%s/config.yaml", e2eProduct, service)] = e2eAppConfig(service, source, env.tags[service].Prod)
+	}
+
+	staging[defaultReviewersFile] = fmt.Sprintf("# service owners\n%s/services/api @alice @@backend\n%s/services/web bob\n", e2eProduct, e2eProduct)
+	prod[defaultPullRequestTemplateFile] = "Promote {{ .Product }} to {{ .Environment }}\n\n{{ range .Services }}- {{ .Service }} {{ .NewImageTag }}\n{{ end }}"
+
+	err = fake.SeedRepository(filepath.Join(remotes, e2eProject, e2eStagingRepo+".git"), "main", staging)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fake.SeedRepository(filepath.Join(remotes, e2eProject, e2eProdRepo+".git"), e2eProdDefault, prod)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.server = fake.NewServer(remotes)
+	t.Cleanup(env.server.Close)
+	env.server.Token = e2eToken
+	env.server.SetGroup("backend", "carol", "e2e")
+	return env
+}
+
+// provider is the Bitbucket Server provider of the fake, authenticating with
+// the token in e2eTokenEnv
+func (env *e2eEnv) provider(t *testing.T, ssh SSHOptions) Provider {
+	provider, err := NewProvider(providerBitbucketServer, ProviderOptions{
+		Project:     e2eProject,
+		ApiUrl:      env.server.ApiUrl(),
+		CloneUrl:    env.server.CloneUrl(),
+		Credentials: CredentialSource{Auth: authToken, PasswordEnv: e2eTokenEnv},
+		SSH:         ssh,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// e2eSeedApp creates the application repository of service and returns the
+// short hashes of the commits deployed to prod, deployed to staging and built
+// last
+func e2eSeedApp(t *testing.T, path string, service string) []string {
+	err := fake.SeedRepository(path, "main", map[string]string{"README.md": service + "\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits := [][]string{
+		{"feat: first production release DEMO-1"},
+		{"fix: handle empty responses\n\nFixes DEMO-2"},
+		{"feat(" + service + "): add health endpoint DEMO-3", "chore: bump dependencies, UTF-8 clean up"},
+	}
+	var hashes []string
+	for i, messages := range commits {
+		var hash string
+		for j, message := range messages {
+			hash, err = fake.CommitFiles(path, "main", message, map[string]string{"VERSION": fmt.Sprintf("%d.%d\n", i, j)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		hashes = append(hashes, hash[:7])
+	}
+	return hashes
+}
+
+func e2eAppConfig(service string, source string, tag string) string {
+	return fmt.Sprintf("app:\n    source: %s\n    path: deploy\n    revision: main\n    image_name: %s\n    image_tag: %s\n", source, service, tag)
+}
+
+// expectFile checks file at the tip of branch in a fake remote contains want
+func (env *e2eEnv) expectFile(t *testing.T, repo string, branch string, file string, want string) {
+	t.Helper()
+	got, err := fake.ReadFile(env.server.RepoPath(e2eProject, repo), branch, file)
+	if err != nil {
+		t.Errorf("%s %s:%s: %s", repo, branch, file, err)
+		return
+	}
+	if !strings.Contains(got, want) {
+		t.Errorf("%s %s:%s: expected %q in\n%s", repo, branch, file, want, got)
+	}
+}
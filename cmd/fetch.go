@@ -0,0 +1,145 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+const (
+	defaultFetchDepth = 10
+	// maxFetchDepth is as far as the history is deepened step by step, past
+	// it the full history is fetched
+	maxFetchDepth = 640
+	// fullHistoryDepth fetches everything, as git fetch --unshallow does
+	fullHistoryDepth = math.MaxInt32
+)
+
+// CloneRelease clones the gitops repository with only the branches the
+// release needs: the release branch, checked out, the target branch and the
+// start point. It is as shallow as FetchDepth allows while the release branch
+// still shares history with the other two, FetchDepth 0 clones everything.
+func (s PrConfig) CloneRelease(repoSlug string, fs billy.Filesystem) (*git.Repository, error) {
+	cloneUrl := s.Provider.CloneURL(repoSlug)
+	auth, err := s.Provider.Auth(cloneUrl)
+	if err != nil {
+		return nil, err
+	}
+	depth := s.FetchDepth
+	logger.Printf("cloning %s at depth %d", cloneUrl, depth)
+	r, err := git.Clone(memory.NewStorage(), fs, &git.CloneOptions{
+		URL:           cloneUrl,
+		Auth:          auth,
+		ReferenceName: plumbing.NewBranchReferenceName(s.SourceBranch),
+		SingleBranch:  true,
+		Depth:         depth,
+		Tags:          git.NoTags,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cloning %s: %s", cloneUrl, err)
+	}
+
+	branches := []string{s.SourceBranch}
+	for _, branch := range []string{s.TargetBranch, s.StartPoint} {
+		if branch != "" && !containsString(branches, branch) {
+			branches = append(branches, branch)
+		}
+	}
+	err = s.fetchBranches(r, repoSlug, depth, branches...)
+	if err != nil {
+		return nil, err
+	}
+	err = s.deepenUntilMergeBase(r, repoSlug, depth, s.SourceBranch, branches[1:])
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// deepenUntilMergeBase deepens the fetched history of branch and others, from
+// depth on, until branch shares history with each of the others
+func (s PrConfig) deepenUntilMergeBase(r *git.Repository, repoSlug string, depth int, branch string, others []string) error {
+	branches := append([]string{branch}, others...)
+	for {
+		missing, err := missingMergeBase(r, branch, others)
+		if err != nil {
+			return err
+		}
+		if missing == "" {
+			return nil
+		}
+		if depth == 0 || depth == fullHistoryDepth {
+			return fmt.Errorf("%s and %s share no history in %s", branch, missing, repoSlug)
+		}
+		depth = nextFetchDepth(depth)
+		if depth == fullHistoryDepth {
+			logger.Printf("no merge base of %s and %s yet, fetching the full history", branch, missing)
+		} else {
+			logger.Printf("no merge base of %s and %s yet, deepening to %d", branch, missing, depth)
+		}
+		err = s.fetchBranches(r, repoSlug, depth, branches...)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// nextFetchDepth doubles depth up to maxFetchDepth, then asks for the full
+// history
+func nextFetchDepth(depth int) int {
+	depth *= 2
+	if depth > maxFetchDepth {
+		return fullHistoryDepth
+	}
+	return depth
+}
+
+// fetchBranches fetches branches of repoSlug into the local branches of the
+// same name, depth 0 meaning no limit
+func (s PrConfig) fetchBranches(r *git.Repository, repoSlug string, depth int, branches ...string) error {
+	cloneUrl := s.Provider.CloneURL(repoSlug)
+	auth, err := s.Provider.Auth(cloneUrl)
+	if err != nil {
+		return err
+	}
+	var refSpecs []config.RefSpec
+	for _, branch := range branches {
+		refSpecs = append(refSpecs, config.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/heads/%s", branch, branch)))
+	}
+	err = r.Fetch(&git.FetchOptions{
+		RefSpecs: refSpecs,
+		Auth:     auth,
+		Depth:    depth,
+		Tags:     git.NoTags,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("fetching %v from %s: %s", branches, cloneUrl, err)
+	}
+	return nil
+}
+
+// missingMergeBase returns the first of others whose merge base with branch
+// is not in the clone yet. A shallow history ends in commits whose parents
+// are missing, walking into them fails until the history is deep enough.
+func missingMergeBase(r *git.Repository, branch string, others []string) (string, error) {
+	head, err := branchCommit(r, branch)
+	if err != nil {
+		return "", err
+	}
+	for _, other := range others {
+		commit, err := branchCommit(r, other)
+		if err != nil {
+			return "", err
+		}
+		bases, err := head.MergeBase(commit)
+		if err != nil || len(bases) == 0 {
+			return other, nil
+		}
+	}
+	return "", nil
+}
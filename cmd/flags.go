@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+// addReleaseFlags defines the flags the staging and prod commands share
+func addReleaseFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().String("bitbucket-project", "", "The repository bitbucket project, or the owner/namespace for other providers")
+	cmd.PersistentFlags().String("source-branch", "", "The branch to create")
+	cmd.PersistentFlags().String("target-branch", "", "The branch the pull request targets (default is the repository's default branch)")
+	cmd.PersistentFlags().String("start-point", "", "The branch the release branch is created from and versions are read from (default is the target branch)")
+	cmd.PersistentFlags().String("product", "", "The product which will also be the top level directory of the repo")
+	cmd.PersistentFlags().StringSlice("services", []string{""}, "A list of the services that will be deployed to staging")
+	cmd.PersistentFlags().String("pr-title-template", "", "Go text/template for the pull request title")
+	cmd.PersistentFlags().String("pr-description-template", "", "Go text/template for the pull request description")
+	cmd.PersistentFlags().String("pr-template-file", "", "Template file in the gitops repo, title on the first line and description below (default "+defaultPullRequestTemplateFile+" when present)")
+	cmd.PersistentFlags().Bool("changelog", false, "Add the application commits between the deployed and new image tag to the pull request description")
+	cmd.PersistentFlags().String("app-source", "", "Application repository clone url, {service} is replaced with the service (default is app.source in config.yaml)")
+	cmd.PersistentFlags().String("changelog-file", "", "Keep the changelog in this file of the gitops repository, implies --changelog")
+	cmd.PersistentFlags().Bool("issue-keys", false, "List the Jira issue keys of the application commits in the pull request description")
+	cmd.PersistentFlags().StringSlice("issue-projects", nil, "Only treat keys of these Jira projects as issue keys, e.g. ABC,DEF")
+	cmd.PersistentFlags().StringSlice("reviewers", nil, "Users and @@groups to add as reviewers, on top of --default-reviewers")
+	cmd.PersistentFlags().String("reviewers-file", "", "CODEOWNERS style file in the gitops repo mapping service paths to reviewers (default "+defaultReviewersFile+" when present)")
+	cmd.PersistentFlags().String("stale-prs", stalePolicyIgnore, "What to do with other open pull requests promoting the same services: ignore, decline them with a link to this one, or fail")
+	cmd.PersistentFlags().String("release-branch-prefix", defaultReleaseBranchPrefix, "Only pull requests from branches starting with this prefix are release pull requests --stale-prs looks at")
+	cmd.PersistentFlags().Bool("report", false, "Post a build status and Code Insights report with the promoted services, findings and tag changes on the release commit")
+	cmd.PersistentFlags().String("report-url", "", "Where the build status links to (default $BUILD_URL, else the release commit)")
+	cmd.PersistentFlags().Bool("comment-on-update", false, "Comment on an existing release pull request with what this run changed")
+}
+
+// newPrConfig reads the shared flags and the settings of cmd into the config
+// of a release to environment. The repository slugs and the options of one
+// command only are left to the command.
+func newPrConfig(cmd *cobra.Command, environment string) PrConfig {
+	bbProject, _ := cmd.Flags().GetString("bitbucket-project")
+	sourceBranch, _ := cmd.Flags().GetString("source-branch")
+	targetBranch, _ := cmd.Flags().GetString("target-branch")
+	startPoint, _ := cmd.Flags().GetString("start-point")
+	product, _ := cmd.Flags().GetString("product")
+	services, _ := cmd.Flags().GetStringSlice("services")
+	commentOnUpdate, _ := cmd.Flags().GetBool("comment-on-update")
+	titleTemplate, _ := cmd.Flags().GetString("pr-title-template")
+	descriptionTemplate, _ := cmd.Flags().GetString("pr-description-template")
+	templateFile, _ := cmd.Flags().GetString("pr-template-file")
+	changelog, _ := cmd.Flags().GetBool("changelog")
+	appSource, _ := cmd.Flags().GetString("app-source")
+	changelogFile, _ := cmd.Flags().GetString("changelog-file")
+	issueKeys, _ := cmd.Flags().GetBool("issue-keys")
+	issueProjects, _ := cmd.Flags().GetStringSlice("issue-projects")
+	reviewers, _ := cmd.Flags().GetStringSlice("reviewers")
+	reviewersFile, _ := cmd.Flags().GetString("reviewers-file")
+	stalePolicy, _ := cmd.Flags().GetString("stale-prs")
+	releaseBranchPrefix, _ := cmd.Flags().GetString("release-branch-prefix")
+	report, _ := cmd.Flags().GetBool("report")
+	reportUrl, _ := cmd.Flags().GetString("report-url")
+	err := validStalePolicy(stalePolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	settings, err := LoadSettings(cmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+	provider, err := NewProvider(settings.Provider, settings.ProviderOptions(bbProject))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return PrConfig{
+		BBProject:       bbProject,
+		SourceBranch:    sourceBranch,
+		TargetBranch:    targetBranch,
+		StartPoint:      startPoint,
+		Product:         product,
+		Services:        services,
+		Provider:        provider,
+		CommentOnUpdate: commentOnUpdate,
+		Release:         &ReleaseReport{},
+
+		TitleTemplate:           titleTemplate,
+		DescriptionTemplate:     descriptionTemplate,
+		PullRequestTemplateFile: templateFile,
+
+		Changelog:     changelog || changelogFile != "",
+		AppSource:     appSource,
+		ChangelogFile: changelogFile,
+
+		IssueKeys:       issueKeys,
+		IssueProjects:   issueProjects,
+		JiraUrl:         settings.JiraUrl,
+		JiraCredentials: settings.JiraCredentials(),
+
+		Reviewers:     settings.Reviewers(environment, reviewers),
+		ReviewersFile: reviewersFile,
+
+		StalePolicy:         stalePolicy,
+		ReleaseBranchPrefix: releaseBranchPrefix,
+
+		Report:    report,
+		ReportUrl: reportUrl,
+
+		Signing:        settings.SigningOptions(),
+		Author:         settings.Author(),
+		Committer:      settings.Committer(),
+		CommitTemplate: settings.CommitTemplate,
+
+		FetchDepth: settings.Depth(),
+	}
+}
@@ -0,0 +1,355 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/internal/rest"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const githubApiUrl = "https://api.github.com"
+
+// GitHub talks to github.com or a GitHub Enterprise instance. Owner is the
+// user or organisation the gitops repositories belong to.
+type GitHub struct {
+	Owner       string
+	ApiUrl      string
+	CloneUrl    string
+	Credentials CredentialSource
+	SSH         SSHOptions
+}
+
+type githubRef struct {
+	Ref    string `json:"ref"`
+	Object struct {
+		Sha string `json:"sha"`
+	} `json:"object"`
+}
+
+type githubCreateRefPayload struct {
+	Ref string `json:"ref"`
+	Sha string `json:"sha"`
+}
+
+type githubPullRequestPayload struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	Head  string `json:"head,omitempty"`
+	Base  string `json:"base,omitempty"`
+}
+
+type githubPullRequest struct {
+	Number  int    `json:"number"`
+	State   string `json:"state"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	HtmlUrl string `json:"html_url"`
+	Head    struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+}
+
+func NewGitHub(owner string, apiUrl string, cloneUrl string, credentials CredentialSource, ssh SSHOptions) GitHub {
+	if apiUrl == "" {
+		apiUrl = githubApiUrl
+	}
+	g := GitHub{Owner: owner, ApiUrl: strings.TrimSuffix(apiUrl, "/"), CloneUrl: cloneUrl, Credentials: credentials, SSH: ssh}
+	g.Credentials = credentials.ForUrls(g.httpsCloneURL(""), g.ApiUrl)
+	return g
+}
+
+func (g GitHub) do(method string, path string, in interface{}, out interface{}) error {
+	_, err := g.doHeader(method, path, in, out)
+	return err
+}
+
+// doHeader is do for the callers that need the response headers
+func (g GitHub) doHeader(method string, path string, in interface{}, out interface{}) (http.Header, error) {
+	creds, err := g.Credentials.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", creds.Secret))
+	header.Set("Accept", "application/vnd.github+json")
+	return rest.Client{Service: providerGitHub, Header: header}.DoHeader(method, g.ApiUrl+path, in, out)
+}
+
+// nextPage returns the rel="next" url of a Link header, empty on the last page
+func nextPage(header http.Header) string {
+	for _, link := range strings.Split(header.Get("Link"), ",") {
+		parts := strings.Split(link, ";")
+		target := strings.Trim(strings.TrimSpace(parts[0]), "<>")
+		for _, param := range parts[1:] {
+			if strings.TrimSpace(param) == `rel="next"` {
+				return target
+			}
+		}
+	}
+	return ""
+}
+
+func (g GitHub) repoPath(repoSlug string) string {
+	return fmt.Sprintf("/repos/%s/%s", url.PathEscape(g.Owner), url.PathEscape(repoSlug))
+}
+
+func (g GitHub) DefaultBranch(repoSlug string) (string, error) {
+	var repo struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	err := g.do("GET", g.repoPath(repoSlug), nil, &repo)
+	if isNotFound(err) {
+		err = fmt.Errorf("repository %s/%s not found: %w", g.Owner, repoSlug, err)
+	}
+	return repo.DefaultBranch, err
+}
+
+func (g GitHub) BranchExists(repoSlug string, branch string) (bool, error) {
+	var ref githubRef
+	err := g.do("GET", fmt.Sprintf("%s/git/ref/heads/%s", g.repoPath(repoSlug), branch), nil, &ref)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return ref.Ref == fmt.Sprintf("refs/heads/%s", branch), nil
+}
+
+func (g GitHub) CreateBranch(repoSlug string, branch string, startPoint string) error {
+	var start githubRef
+	err := g.do("GET", fmt.Sprintf("%s/git/ref/heads/%s", g.repoPath(repoSlug), startPoint), nil, &start)
+	if isNotFound(err) {
+		return fmt.Errorf("start point %s not found in %s/%s: %w", startPoint, g.Owner, repoSlug, err)
+	}
+	if err != nil {
+		return err
+	}
+	body := githubCreateRefPayload{
+		Ref: fmt.Sprintf("refs/heads/%s", branch),
+		Sha: start.Object.Sha,
+	}
+	return g.do("POST", fmt.Sprintf("%s/git/refs", g.repoPath(repoSlug)), body, nil)
+}
+
+func (g GitHub) FindPullRequest(repoSlug string, sourceBranch string, targetBranch string) (*PullRequest, error) {
+	var prs []githubPullRequest
+	query := url.Values{}
+	query.Set("head", fmt.Sprintf("%s:%s", g.Owner, sourceBranch))
+	query.Set("base", targetBranch)
+	query.Set("state", "open")
+	err := g.do("GET", fmt.Sprintf("%s/pulls?%s", g.repoPath(repoSlug), query.Encode()), nil, &prs)
+	if err != nil || len(prs) == 0 {
+		return nil, err
+	}
+	return &PullRequest{
+		ID:           prs[0].Number,
+		Url:          prs[0].HtmlUrl,
+		Title:        prs[0].Title,
+		Description:  prs[0].Body,
+		SourceBranch: sourceBranch,
+		TargetBranch: targetBranch,
+	}, nil
+}
+
+// ListPullRequests returns the open pull requests into targetBranch,
+// following the Link header through every page
+func (g GitHub) ListPullRequests(repoSlug string, targetBranch string) ([]PullRequest, error) {
+	query := url.Values{}
+	query.Set("base", targetBranch)
+	query.Set("state", "open")
+	query.Set("per_page", "100")
+	var open []PullRequest
+	next := fmt.Sprintf("%s/pulls?%s", g.repoPath(repoSlug), query.Encode())
+	for next != "" {
+		var prs []githubPullRequest
+		header, err := g.doHeader("GET", next, nil, &prs)
+		if err != nil {
+			return nil, err
+		}
+		for _, pr := range prs {
+			open = append(open, PullRequest{
+				ID:           pr.Number,
+				Url:          pr.HtmlUrl,
+				Title:        pr.Title,
+				Description:  pr.Body,
+				SourceBranch: pr.Head.Ref,
+				TargetBranch: targetBranch,
+			})
+		}
+		next = strings.TrimPrefix(nextPage(header), g.ApiUrl)
+	}
+	return open, nil
+}
+
+// requestReviewers asks users and @@teams for a review, GitHub ignores the
+// ones already requested
+func (g GitHub) requestReviewers(repoSlug string, number int, reviewers []string) error {
+	users, teams := splitReviewers(reviewers, g.Credentials.Username())
+	if len(users) == 0 && len(teams) == 0 {
+		return nil
+	}
+	body := map[string][]string{"reviewers": users, "team_reviewers": teams}
+	return g.do("POST", fmt.Sprintf("%s/pulls/%d/requested_reviewers", g.repoPath(repoSlug), number), body, nil)
+}
+
+func (g GitHub) OpenPullRequest(repoSlug string, pr PullRequest) error {
+	body := githubPullRequestPayload{
+		Title: pr.Title,
+		Body:  pr.Description,
+		Head:  pr.SourceBranch,
+		Base:  pr.TargetBranch,
+	}
+	var created githubPullRequest
+	err := g.do("POST", fmt.Sprintf("%s/pulls", g.repoPath(repoSlug)), body, &created)
+	if err != nil {
+		return err
+	}
+	logger.Printf("opened pull request #%d %s", created.Number, created.HtmlUrl)
+	return g.requestReviewers(repoSlug, created.Number, pr.Reviewers)
+}
+
+func (g GitHub) UpdatePullRequest(repoSlug string, pr PullRequest) error {
+	body := githubPullRequestPayload{
+		Title: pr.Title,
+		Body:  pr.Description,
+	}
+	err := g.do("PATCH", fmt.Sprintf("%s/pulls/%d", g.repoPath(repoSlug), pr.ID), body, nil)
+	if err != nil {
+		return err
+	}
+	return g.requestReviewers(repoSlug, pr.ID, pr.Reviewers)
+}
+
+// CommentPullRequest comments through the issues API, pull request comments
+// proper are review comments attached to a diff line
+func (g GitHub) CommentPullRequest(repoSlug string, pr PullRequest, text string) error {
+	body := map[string]string{"body": text}
+	return g.do("POST", fmt.Sprintf("%s/issues/%d/comments", g.repoPath(repoSlug), pr.ID), body, nil)
+}
+
+// DeclinePullRequest closes the pull request without merging it
+func (g GitHub) DeclinePullRequest(repoSlug string, pr PullRequest) error {
+	body := map[string]string{"state": "closed"}
+	return g.do("PATCH", fmt.Sprintf("%s/pulls/%d", g.repoPath(repoSlug), pr.ID), body, nil)
+}
+
+// MergeStatus reads GitHub's mergeable_state. "blocked" covers both missing
+// approvals and failing required checks, so reviews asking for changes are
+// looked up to tell them apart.
+func (g GitHub) MergeStatus(repoSlug string, pr PullRequest) (*MergeStatus, error) {
+	var current struct {
+		State          string `json:"state"`
+		Mergeable      *bool  `json:"mergeable"`
+		MergeableState string `json:"mergeable_state"`
+	}
+	err := g.do("GET", fmt.Sprintf("%s/pulls/%d", g.repoPath(repoSlug), pr.ID), nil, &current)
+	if err != nil {
+		return nil, err
+	}
+	status := &MergeStatus{}
+	switch current.MergeableState {
+	case "clean", "has_hooks", "unstable":
+		status.Mergeable = current.Mergeable != nil && *current.Mergeable
+	case "dirty":
+		status.Blocked = true
+		status.Reasons = append(status.Reasons, "the pull request has conflicts")
+	case "behind":
+		status.Blocked = true
+		status.Reasons = append(status.Reasons, "the head branch is behind the base branch")
+	case "draft":
+		status.Blocked = true
+		status.Reasons = append(status.Reasons, "the pull request is a draft")
+	default:
+		status.Reasons = append(status.Reasons, fmt.Sprintf("mergeable state is %s", current.MergeableState))
+	}
+
+	var reviews []struct {
+		State string `json:"state"`
+		User  struct {
+			Login string `json:"login"`
+		} `json:"user"`
+	}
+	err = g.do("GET", fmt.Sprintf("%s/pulls/%d/reviews", g.repoPath(repoSlug), pr.ID), nil, &reviews)
+	if err != nil {
+		return nil, err
+	}
+	latest := map[string]string{}
+	for _, review := range reviews {
+		latest[review.User.Login] = review.State
+	}
+	for user, state := range latest {
+		if state == "CHANGES_REQUESTED" {
+			status.Mergeable = false
+			status.Blocked = true
+			status.Reasons = append(status.Reasons, fmt.Sprintf("%s requested changes", user))
+		}
+	}
+	return status, nil
+}
+
+// MergePullRequest merges with strategy as the merge method: merge, squash or
+// rebase
+func (g GitHub) MergePullRequest(repoSlug string, pr PullRequest, strategy string) error {
+	if strategy == "" {
+		strategy = "merge"
+	}
+	body := map[string]string{"merge_method": strategy}
+	err := g.do("PUT", fmt.Sprintf("%s/pulls/%d/merge", g.repoPath(repoSlug), pr.ID), body, nil)
+	if err != nil {
+		return err
+	}
+	logger.Printf("merged pull request #%d", pr.ID)
+	return nil
+}
+
+// ReportCommit sets a commit status. Check runs with annotations need a GitHub
+// App, so the findings only show in the status description.
+func (g GitHub) ReportCommit(repoSlug string, commit string, report CommitReport) error {
+	state := "success"
+	if !report.Passed {
+		state = "failure"
+	}
+	body := map[string]string{
+		"state":       state,
+		"context":     report.Key,
+		"description": truncate(report.Summary, 140),
+	}
+	if report.Url != "" {
+		body["target_url"] = report.Url
+	}
+	return g.do("POST", fmt.Sprintf("%s/statuses/%s", g.repoPath(repoSlug), commit), body, nil)
+}
+
+func (g GitHub) CloneURL(repoSlug string) string {
+	return g.SSH.CloneURL(repoSlug, g.Owner, g.httpsCloneURL(repoSlug))
+}
+
+// httpsCloneURL derives the web host from the API url, api.github.com for
+// github.com and <host>/api/v3 for GitHub Enterprise.
+func (g GitHub) httpsCloneURL(repoSlug string) string {
+	if g.CloneUrl != "" {
+		return expandCloneUrl(g.CloneUrl, g.Owner, repoSlug)
+	}
+	host := strings.TrimSuffix(g.ApiUrl, "/api/v3")
+	if host == githubApiUrl {
+		host = "https://github.com"
+	}
+	return fmt.Sprintf("%s/%s/%s.git", host, g.Owner, repoSlug)
+}
+
+// Auth clones with the token as the password of x-access-token, or of the
+// user with basic auth
+func (g GitHub) Auth(cloneUrl string) (transport.AuthMethod, error) {
+	if isSSHUrl(cloneUrl) {
+		return g.SSH.Auth(cloneUrl)
+	}
+	creds, err := g.Credentials.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return creds.GitAuth("x-access-token"), nil
+}
@@ -0,0 +1,336 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeGitHub serves the parts of the GitHub REST API the provider uses for a
+// single repository
+type fakeGitHub struct {
+	fakeAPI
+	Owner         string
+	Repo          string
+	DefaultBranch string
+	Branches      map[string]string
+	Pulls         []*fakeGitHubPull
+	Statuses      map[string][]map[string]string
+}
+
+type fakeGitHubPull struct {
+	githubPullRequest
+	Base      string
+	Reviewers []string
+	Teams     []string
+	Comments  []string
+	// Reviews are the latest review state of each reviewer
+	Reviews        map[string]string
+	MergeableState string
+	Merged         string
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, GitHub) {
+	t.Setenv(password, "github-token")
+	f := &fakeGitHub{
+		Owner:         "acme",
+		Repo:          "gitops",
+		DefaultBranch: "main",
+		Branches:      map[string]string{"main": "1111111111111111111111111111111111111111"},
+		Statuses:      map[string][]map[string]string{},
+	}
+	f.fakeAPI = fakeAPI{Prefix: "/repos/acme/gitops", Header: "Authorization", Token: "Bearer github-token", Handle: f.handle}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, NewGitHub(f.Owner, server.URL, "", CredentialSource{Auth: authToken}, SSHOptions{})
+}
+
+func (f *fakeGitHub) handle(w http.ResponseWriter, r *http.Request, path []string, fields map[string]interface{}, body interface{}) {
+	switch {
+	case r.Method == "GET" && path[0] == "":
+		writeJSON(w, map[string]string{"default_branch": f.DefaultBranch})
+	case r.Method == "GET" && len(path) >= 4 && path[0] == "git" && path[1] == "ref":
+		name := strings.Join(path[3:], "/")
+		sha, ok := f.Branches[name]
+		if !ok {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		ref := githubRef{Ref: "refs/heads/" + name}
+		ref.Object.Sha = sha
+		writeJSON(w, ref)
+	case r.Method == "POST" && len(path) == 2 && path[1] == "refs":
+		name := strings.TrimPrefix(fields["ref"].(string), "refs/heads/")
+		if _, ok := f.Branches[name]; ok {
+			writeError(w, http.StatusUnprocessableEntity)
+			return
+		}
+		f.Branches[name] = fields["sha"].(string)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "GET" && len(path) == 1 && path[0] == "pulls":
+		query := r.URL.Query()
+		var found []githubPullRequest
+		for _, pull := range f.Pulls {
+			head := fmt.Sprintf("%s:%s", f.Owner, pull.Head.Ref)
+			if pull.State == query.Get("state") && pull.Base == query.Get("base") && (query.Get("head") == "" || query.Get("head") == head) {
+				found = append(found, pull.githubPullRequest)
+			}
+		}
+		start, end, next := f.page(r, len(found))
+		if next > 0 {
+			w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="first", <%s>; rel="next"`, pageUrl(r, 1), pageUrl(r, next)))
+		}
+		writeJSON(w, found[start:end])
+	case r.Method == "POST" && len(path) == 1 && path[0] == "pulls":
+		pull := &fakeGitHubPull{Base: fields["base"].(string), MergeableState: "clean"}
+		pull.Head.Ref = fields["head"].(string)
+		pull.Number = len(f.Pulls) + 1
+		pull.State = "open"
+		pull.Title = fields["title"].(string)
+		pull.Body = fields["body"].(string)
+		pull.HtmlUrl = fmt.Sprintf("https://github.example.com/%s/%s/pull/%d", f.Owner, f.Repo, pull.Number)
+		f.Pulls = append(f.Pulls, pull)
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, pull.githubPullRequest)
+	case len(path) >= 2 && (path[0] == "pulls" || path[0] == "issues"):
+		pull := f.pull(path[1])
+		if pull == nil {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.Method == "GET" && len(path) == 2:
+			writeJSON(w, map[string]interface{}{"state": pull.State, "mergeable": pull.MergeableState == "clean", "mergeable_state": pull.MergeableState})
+		case r.Method == "GET" && len(path) == 3 && path[2] == "reviews":
+			reviews := []map[string]interface{}{}
+			for user, state := range pull.Reviews {
+				reviews = append(reviews, map[string]interface{}{"state": state, "user": map[string]string{"login": user}})
+			}
+			writeJSON(w, reviews)
+		case r.Method == "PATCH" && len(path) == 2:
+			if title, ok := fields["title"].(string); ok {
+				pull.Title = title
+			}
+			if description, ok := fields["body"].(string); ok {
+				pull.Body = description
+			}
+			if state, ok := fields["state"].(string); ok {
+				pull.State = state
+			}
+			writeJSON(w, pull.githubPullRequest)
+		case r.Method == "POST" && len(path) == 3 && path[2] == "requested_reviewers":
+			reviewers, _ := fields["reviewers"].([]interface{})
+			for _, reviewer := range reviewers {
+				pull.Reviewers = append(pull.Reviewers, reviewer.(string))
+			}
+			teams, _ := fields["team_reviewers"].([]interface{})
+			for _, team := range teams {
+				pull.Teams = append(pull.Teams, team.(string))
+			}
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "POST" && len(path) == 3 && path[2] == "comments":
+			pull.Comments = append(pull.Comments, fields["body"].(string))
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "PUT" && len(path) == 3 && path[2] == "merge":
+			pull.State = "closed"
+			pull.Merged = fields["merge_method"].(string)
+			writeJSON(w, map[string]bool{"merged": true})
+		default:
+			writeError(w, http.StatusNotFound)
+		}
+	case r.Method == "POST" && len(path) == 2 && path[0] == "statuses":
+		status := map[string]string{}
+		for k, v := range fields {
+			status[k] = v.(string)
+		}
+		f.Statuses[path[1]] = append(f.Statuses[path[1]], status)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		writeError(w, http.StatusNotFound)
+	}
+}
+
+func (f *fakeGitHub) pull(number string) *fakeGitHubPull {
+	n, _ := strconv.Atoi(number)
+	for _, pull := range f.Pulls {
+		if pull.Number == n {
+			return pull
+		}
+	}
+	return nil
+}
+
+func TestGitHubBranches(t *testing.T) {
+	fake, github := newFakeGitHub(t)
+
+	branch, err := github.DefaultBranch(fake.Repo)
+	if err != nil || branch != "main" {
+		t.Fatalf("DefaultBranch = %q, %v, want main", branch, err)
+	}
+	exists, err := github.BranchExists(fake.Repo, "release/1.0")
+	if err != nil || exists {
+		t.Fatalf("BranchExists(release/1.0) = %v, %v before it was created", exists, err)
+	}
+	err = github.CreateBranch(fake.Repo, "release/1.0", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err = github.BranchExists(fake.Repo, "release/1.0")
+	if err != nil || !exists {
+		t.Fatalf("BranchExists(release/1.0) = %v, %v after it was created", exists, err)
+	}
+	if fake.Branches["release/1.0"] != fake.Branches["main"] {
+		t.Errorf("release/1.0 was created at %s, want the main commit", fake.Branches["release/1.0"])
+	}
+	err = github.CreateBranch(fake.Repo, "release/1.1", "develop")
+	if err == nil || !strings.Contains(err.Error(), "start point develop not found") {
+		t.Errorf("CreateBranch from a missing start point returned %v", err)
+	}
+}
+
+func TestGitHubPullRequests(t *testing.T) {
+	fake, github := newFakeGitHub(t)
+
+	pr, err := github.FindPullRequest(fake.Repo, "release/1.0", "main")
+	if err != nil || pr != nil {
+		t.Fatalf("FindPullRequest = %v, %v before it was opened", pr, err)
+	}
+	err = github.OpenPullRequest(fake.Repo, PullRequest{
+		Title:        "Release 1.0",
+		Description:  "first",
+		SourceBranch: "release/1.0",
+		TargetBranch: "main",
+		Reviewers:    []string{"alice", "@@backend"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err = github.FindPullRequest(fake.Repo, "release/1.0", "main")
+	if err != nil || pr == nil {
+		t.Fatalf("FindPullRequest = %v, %v after it was opened", pr, err)
+	}
+	if pr.ID != 1 || pr.Title != "Release 1.0" || pr.Description != "first" || pr.Url == "" {
+		t.Errorf("found %+v", pr)
+	}
+	other, err := github.FindPullRequest(fake.Repo, "release/1.0", "develop")
+	if err != nil || other != nil {
+		t.Errorf("FindPullRequest into develop = %v, %v", other, err)
+	}
+
+	pr.Title = "Release 1.0 (updated)"
+	pr.Description = "second"
+	pr.Reviewers = []string{"bob"}
+	err = github.UpdatePullRequest(fake.Repo, *pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = github.CommentPullRequest(fake.Repo, *pr, "updated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pull := fake.Pulls[0]
+	if pull.Title != "Release 1.0 (updated)" || pull.Body != "second" || pull.Base != "main" {
+		t.Errorf("pull request is %q: %q into %s after the update", pull.Title, pull.Body, pull.Base)
+	}
+	if strings.Join(pull.Reviewers, ",") != "alice,bob" || strings.Join(pull.Teams, ",") != "backend" {
+		t.Errorf("reviewers are %v and teams %v, want alice,bob and backend", pull.Reviewers, pull.Teams)
+	}
+	if strings.Join(pull.Comments, ",") != "updated" {
+		t.Errorf("comments are %v", pull.Comments)
+	}
+}
+
+func TestGitHubListPullRequests(t *testing.T) {
+	fake, github := newFakeGitHub(t)
+	testListPullRequests(t, &fake.fakeAPI, github, fake.Repo)
+}
+
+func TestGitHubReportCommit(t *testing.T) {
+	fake, github := newFakeGitHub(t)
+	err := github.ReportCommit(fake.Repo, "abc", CommitReport{
+		Key:     reportKey,
+		Passed:  false,
+		Summary: strings.Repeat("x", 200),
+		Url:     "https://ci.example.com/1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := fake.Statuses["abc"]
+	if len(statuses) != 1 || statuses[0]["state"] != "failure" || statuses[0]["context"] != reportKey || statuses[0]["target_url"] != "https://ci.example.com/1" {
+		t.Fatalf("statuses are %v", statuses)
+	}
+	if len(statuses[0]["description"]) > 140 {
+		t.Errorf("description is %d long, GitHub takes 140", len(statuses[0]["description"]))
+	}
+}
+
+func TestGitHubWriteErrors(t *testing.T) {
+	fake, github := newFakeGitHub(t)
+	err := github.OpenPullRequest(fake.Repo, PullRequest{Title: "Release 1.0", SourceBranch: "release/1.0", TargetBranch: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := github.FindPullRequest(fake.Repo, "release/1.0", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongOwner := github
+	wrongOwner.Owner = "someone-else"
+	testWriteErrors(t, &fake.fakeAPI, github, wrongOwner, fake.Repo, *pr)
+	if _, ok := fake.Branches["release/1.1"]; ok || len(fake.Pulls) != 1 || len(fake.Pulls[0].Comments) != 0 || fake.Pulls[0].State != "open" {
+		t.Errorf("a failed write changed the repository")
+	}
+}
+
+func TestGitHubMergeStatus(t *testing.T) {
+	fake, github := newFakeGitHub(t)
+	err := github.OpenPullRequest(fake.Repo, PullRequest{Title: "Release 1.0", SourceBranch: "release/1.0", TargetBranch: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := PullRequest{ID: 1, SourceBranch: "release/1.0", TargetBranch: "main"}
+	pull := fake.Pulls[0]
+
+	for _, c := range []struct {
+		name           string
+		mergeableState string
+		reviews        map[string]string
+		mergeable      bool
+		blocked        bool
+		reasons        string
+	}{
+		{name: "clean", mergeableState: "clean", mergeable: true},
+		{name: "waiting for approvals", mergeableState: "blocked", reasons: "mergeable state is blocked"},
+		{name: "conflicts", mergeableState: "dirty", blocked: true, reasons: "the pull request has conflicts"},
+		{
+			name:           "changes requested",
+			mergeableState: "blocked",
+			reviews:        map[string]string{"alice": "APPROVED", "bob": "CHANGES_REQUESTED"},
+			blocked:        true,
+			reasons:        "mergeable state is blocked; bob requested changes",
+		},
+	} {
+		pull.MergeableState = c.mergeableState
+		pull.Reviews = c.reviews
+		status, err := github.MergeStatus(fake.Repo, pr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if status.Mergeable != c.mergeable || status.Blocked != c.blocked || strings.Join(status.Reasons, "; ") != c.reasons {
+			t.Errorf("%s: got %+v", c.name, status)
+		}
+	}
+
+	err = github.MergePullRequest(fake.Repo, pr, "squash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pull.State != "closed" || pull.Merged != "squash" {
+		t.Errorf("pull request is %s with method %q, want closed with squash", pull.State, pull.Merged)
+	}
+}
@@ -0,0 +1,331 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/internal/rest"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const gitlabApiUrl = "https://gitlab.com/api/v4"
+
+// GitLab talks to gitlab.com or a self-managed GitLab. Namespace is the group
+// (or group/subgroup) the gitops projects belong to.
+type GitLab struct {
+	Namespace   string
+	ApiUrl      string
+	CloneUrl    string
+	Credentials CredentialSource
+	SSH         SSHOptions
+}
+
+type gitlabBranch struct {
+	Name   string `json:"name"`
+	Commit struct {
+		ID string `json:"id"`
+	} `json:"commit"`
+}
+
+type gitlabMergeRequestPayload struct {
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	ReviewerIDs  []int  `json:"reviewer_ids,omitempty"`
+}
+
+type gitlabMergeRequest struct {
+	IID          int    `json:"iid"`
+	State        string `json:"state"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	SourceBranch string `json:"source_branch"`
+	TargetBranch string `json:"target_branch"`
+	WebUrl       string `json:"web_url"`
+	Reviewers    []struct {
+		ID int `json:"id"`
+	} `json:"reviewers"`
+}
+
+func NewGitLab(namespace string, apiUrl string, cloneUrl string, credentials CredentialSource, ssh SSHOptions) GitLab {
+	if apiUrl == "" {
+		apiUrl = gitlabApiUrl
+	}
+	g := GitLab{Namespace: namespace, ApiUrl: strings.TrimSuffix(apiUrl, "/"), CloneUrl: cloneUrl, Credentials: credentials, SSH: ssh}
+	g.Credentials = credentials.ForUrls(g.httpsCloneURL(""), g.ApiUrl)
+	return g
+}
+
+// do sends the secret as a personal, project or group access token, the REST
+// API has no basic auth
+func (g GitLab) do(method string, path string, in interface{}, out interface{}) error {
+	_, err := g.doHeader(method, path, in, out)
+	return err
+}
+
+// doHeader is do for the callers that need the response headers
+func (g GitLab) doHeader(method string, path string, in interface{}, out interface{}) (http.Header, error) {
+	creds, err := g.Credentials.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	header := http.Header{}
+	header.Set("PRIVATE-TOKEN", creds.Secret)
+	return rest.Client{Service: providerGitLab, Header: header}.DoHeader(method, g.ApiUrl+path, in, out)
+}
+
+// projectPath addresses a project by its url encoded full path, which saves a
+// lookup of the numeric project id.
+func (g GitLab) projectPath(repoSlug string) string {
+	return fmt.Sprintf("/projects/%s", url.QueryEscape(fmt.Sprintf("%s/%s", g.Namespace, repoSlug)))
+}
+
+func (g GitLab) DefaultBranch(repoSlug string) (string, error) {
+	var project struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	err := g.do("GET", g.projectPath(repoSlug), nil, &project)
+	if isNotFound(err) {
+		err = fmt.Errorf("project %s/%s not found: %w", g.Namespace, repoSlug, err)
+	}
+	return project.DefaultBranch, err
+}
+
+func (g GitLab) BranchExists(repoSlug string, branch string) (bool, error) {
+	var b gitlabBranch
+	err := g.do("GET", fmt.Sprintf("%s/repository/branches/%s", g.projectPath(repoSlug), url.QueryEscape(branch)), nil, &b)
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return b.Name == branch, nil
+}
+
+func (g GitLab) CreateBranch(repoSlug string, branch string, startPoint string) error {
+	query := url.Values{}
+	query.Set("branch", branch)
+	query.Set("ref", startPoint)
+	return g.do("POST", fmt.Sprintf("%s/repository/branches?%s", g.projectPath(repoSlug), query.Encode()), nil, nil)
+}
+
+func (g GitLab) FindPullRequest(repoSlug string, sourceBranch string, targetBranch string) (*PullRequest, error) {
+	var mrs []gitlabMergeRequest
+	query := url.Values{}
+	query.Set("source_branch", sourceBranch)
+	query.Set("target_branch", targetBranch)
+	query.Set("state", "opened")
+	err := g.do("GET", fmt.Sprintf("%s/merge_requests?%s", g.projectPath(repoSlug), query.Encode()), nil, &mrs)
+	if err != nil || len(mrs) == 0 {
+		return nil, err
+	}
+	return &PullRequest{
+		ID:           mrs[0].IID,
+		Url:          mrs[0].WebUrl,
+		Title:        mrs[0].Title,
+		Description:  mrs[0].Description,
+		SourceBranch: sourceBranch,
+		TargetBranch: targetBranch,
+	}, nil
+}
+
+// ListPullRequests returns the open merge requests into targetBranch,
+// following the X-Next-Page header through every page
+func (g GitLab) ListPullRequests(repoSlug string, targetBranch string) ([]PullRequest, error) {
+	query := url.Values{}
+	query.Set("target_branch", targetBranch)
+	query.Set("state", "opened")
+	query.Set("per_page", "100")
+	var open []PullRequest
+	for page := "1"; page != ""; {
+		query.Set("page", page)
+		var mrs []gitlabMergeRequest
+		header, err := g.doHeader("GET", fmt.Sprintf("%s/merge_requests?%s", g.projectPath(repoSlug), query.Encode()), nil, &mrs)
+		if err != nil {
+			return nil, err
+		}
+		for _, mr := range mrs {
+			open = append(open, PullRequest{
+				ID:           mr.IID,
+				Url:          mr.WebUrl,
+				Title:        mr.Title,
+				Description:  mr.Description,
+				SourceBranch: mr.SourceBranch,
+				TargetBranch: targetBranch,
+			})
+		}
+		page = header.Get("X-Next-Page")
+	}
+	return open, nil
+}
+
+// reviewerIDs looks up the user ids of the reviewers and adds them to current.
+// GitLab has no group reviewers, @@groups are skipped.
+func (g GitLab) reviewerIDs(current []int, reviewers []string) ([]int, error) {
+	users, groups := splitReviewers(reviewers, g.Credentials.Username())
+	if len(groups) > 0 {
+		logger.Printf("gitlab has no group reviewers, ignoring %v", groups)
+	}
+	ids := append([]int(nil), current...)
+	for _, user := range users {
+		var found []struct {
+			ID int `json:"id"`
+		}
+		err := g.do("GET", fmt.Sprintf("/users?username=%s", url.QueryEscape(user)), nil, &found)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("reviewer %s not found", user)
+		}
+		known := false
+		for _, id := range ids {
+			known = known || id == found[0].ID
+		}
+		if !known {
+			ids = append(ids, found[0].ID)
+		}
+	}
+	return ids, nil
+}
+
+func (g GitLab) OpenPullRequest(repoSlug string, pr PullRequest) error {
+	reviewerIDs, err := g.reviewerIDs(nil, pr.Reviewers)
+	if err != nil {
+		return err
+	}
+	body := gitlabMergeRequestPayload{
+		SourceBranch: pr.SourceBranch,
+		TargetBranch: pr.TargetBranch,
+		Title:        pr.Title,
+		Description:  pr.Description,
+		ReviewerIDs:  reviewerIDs,
+	}
+	var created gitlabMergeRequest
+	err = g.do("POST", fmt.Sprintf("%s/merge_requests", g.projectPath(repoSlug)), body, &created)
+	if err != nil {
+		return err
+	}
+	logger.Printf("opened merge request !%d %s", created.IID, created.WebUrl)
+	return nil
+}
+
+// UpdatePullRequest keeps the reviewers the merge request has, reviewer_ids
+// replaces them
+func (g GitLab) UpdatePullRequest(repoSlug string, pr PullRequest) error {
+	body := map[string]interface{}{"title": pr.Title, "description": pr.Description}
+	if len(pr.Reviewers) > 0 {
+		var current gitlabMergeRequest
+		err := g.do("GET", fmt.Sprintf("%s/merge_requests/%d", g.projectPath(repoSlug), pr.ID), nil, &current)
+		if err != nil {
+			return err
+		}
+		var ids []int
+		for _, reviewer := range current.Reviewers {
+			ids = append(ids, reviewer.ID)
+		}
+		ids, err = g.reviewerIDs(ids, pr.Reviewers)
+		if err != nil {
+			return err
+		}
+		body["reviewer_ids"] = ids
+	}
+	return g.do("PUT", fmt.Sprintf("%s/merge_requests/%d", g.projectPath(repoSlug), pr.ID), body, nil)
+}
+
+func (g GitLab) CommentPullRequest(repoSlug string, pr PullRequest, text string) error {
+	body := map[string]string{"body": text}
+	return g.do("POST", fmt.Sprintf("%s/merge_requests/%d/notes", g.projectPath(repoSlug), pr.ID), body, nil)
+}
+
+// DeclinePullRequest closes the merge request without merging it
+func (g GitLab) DeclinePullRequest(repoSlug string, pr PullRequest) error {
+	body := map[string]string{"state_event": "close"}
+	return g.do("PUT", fmt.Sprintf("%s/merge_requests/%d", g.projectPath(repoSlug), pr.ID), body, nil)
+}
+
+// MergeStatus maps GitLab's detailed_merge_status onto mergeable, pending
+// (approvals, pipelines, checks still running) and blocked
+func (g GitLab) MergeStatus(repoSlug string, pr PullRequest) (*MergeStatus, error) {
+	var current struct {
+		DetailedMergeStatus string `json:"detailed_merge_status"`
+		HasConflicts        bool   `json:"has_conflicts"`
+	}
+	err := g.do("GET", fmt.Sprintf("%s/merge_requests/%d", g.projectPath(repoSlug), pr.ID), nil, &current)
+	if err != nil {
+		return nil, err
+	}
+	status := &MergeStatus{}
+	switch current.DetailedMergeStatus {
+	case "mergeable":
+		status.Mergeable = true
+	case "not_approved", "ci_must_pass", "ci_still_running", "checking", "unchecked", "approvals_syncing", "preparing":
+		status.Reasons = append(status.Reasons, current.DetailedMergeStatus)
+	default:
+		status.Blocked = true
+		status.Reasons = append(status.Reasons, current.DetailedMergeStatus)
+	}
+	if current.HasConflicts {
+		status.Mergeable = false
+		status.Blocked = true
+	}
+	return status, nil
+}
+
+// MergePullRequest merges, squashing the commits when strategy is squash
+func (g GitLab) MergePullRequest(repoSlug string, pr PullRequest, strategy string) error {
+	body := map[string]bool{"squash": strategy == "squash"}
+	err := g.do("PUT", fmt.Sprintf("%s/merge_requests/%d/merge", g.projectPath(repoSlug), pr.ID), body, nil)
+	if err != nil {
+		return err
+	}
+	logger.Printf("merged merge request !%d", pr.ID)
+	return nil
+}
+
+// ReportCommit sets a commit status, GitLab has no report API outside of CI
+// pipelines
+func (g GitLab) ReportCommit(repoSlug string, commit string, report CommitReport) error {
+	state := "success"
+	if !report.Passed {
+		state = "failed"
+	}
+	body := map[string]string{
+		"state":       state,
+		"name":        report.Key,
+		"description": truncate(report.Summary, 255),
+	}
+	if report.Url != "" {
+		body["target_url"] = report.Url
+	}
+	return g.do("POST", fmt.Sprintf("%s/statuses/%s", g.projectPath(repoSlug), commit), body, nil)
+}
+
+func (g GitLab) CloneURL(repoSlug string) string {
+	return g.SSH.CloneURL(repoSlug, g.Namespace, g.httpsCloneURL(repoSlug))
+}
+
+func (g GitLab) httpsCloneURL(repoSlug string) string {
+	if g.CloneUrl != "" {
+		return expandCloneUrl(g.CloneUrl, g.Namespace, repoSlug)
+	}
+	host := strings.TrimSuffix(g.ApiUrl, "/api/v4")
+	return fmt.Sprintf("%s/%s/%s.git", host, g.Namespace, repoSlug)
+}
+
+// Auth uses the token as an oauth2 password, which GitLab accepts for personal,
+// project and group access tokens alike.
+func (g GitLab) Auth(cloneUrl string) (transport.AuthMethod, error) {
+	if isSSHUrl(cloneUrl) {
+		return g.SSH.Auth(cloneUrl)
+	}
+	creds, err := g.Credentials.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	return creds.GitAuth("oauth2"), nil
+}
@@ -0,0 +1,331 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/internal/rest"
+)
+
+// fakeGitLab serves the parts of the GitLab REST API the provider uses for a
+// single project
+type fakeGitLab struct {
+	fakeAPI
+	Namespace     string
+	Project       string
+	DefaultBranch string
+	Branches      map[string]string
+	MergeRequests []*fakeGitLabMergeRequest
+	Users         map[string]int
+	Statuses      map[string][]map[string]string
+}
+
+type fakeGitLabMergeRequest struct {
+	gitlabMergeRequest
+	Notes               []string `json:"-"`
+	DetailedMergeStatus string   `json:"detailed_merge_status"`
+	HasConflicts        bool     `json:"has_conflicts"`
+	Squashed            bool     `json:"-"`
+}
+
+func newFakeGitLab(t *testing.T) (*fakeGitLab, GitLab) {
+	t.Setenv(password, "gitlab-token")
+	f := &fakeGitLab{
+		Namespace:     "acme/platform",
+		Project:       "gitops",
+		DefaultBranch: "main",
+		Branches:      map[string]string{"main": "1111111111111111111111111111111111111111"},
+		Users:         map[string]int{"alice": 1, "bob": 2},
+		Statuses:      map[string][]map[string]string{},
+	}
+	f.fakeAPI = fakeAPI{Prefix: "/projects/acme/platform/gitops", Global: []string{"/users"}, Header: "PRIVATE-TOKEN", Token: "gitlab-token", Handle: f.handle}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	return f, NewGitLab(f.Namespace, server.URL, "", CredentialSource{Auth: authToken}, SSHOptions{})
+}
+
+func (f *fakeGitLab) handle(w http.ResponseWriter, r *http.Request, path []string, fields map[string]interface{}, body interface{}) {
+	query := r.URL.Query()
+	switch {
+	case r.Method == "GET" && path[0] == "users":
+		found := []map[string]int{}
+		if id, ok := f.Users[query.Get("username")]; ok {
+			found = append(found, map[string]int{"id": id})
+		}
+		writeJSON(w, found)
+	case r.Method == "GET" && path[0] == "":
+		writeJSON(w, map[string]string{"default_branch": f.DefaultBranch})
+	case r.Method == "GET" && len(path) >= 3 && path[1] == "branches":
+		name := strings.Join(path[2:], "/")
+		sha, ok := f.Branches[name]
+		if !ok {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		branch := gitlabBranch{Name: name}
+		branch.Commit.ID = sha
+		writeJSON(w, branch)
+	case r.Method == "POST" && len(path) == 2 && path[1] == "branches":
+		sha, ok := f.Branches[query.Get("ref")]
+		if !ok {
+			writeError(w, http.StatusBadRequest)
+			return
+		}
+		f.Branches[query.Get("branch")] = sha
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "GET" && len(path) == 1 && path[0] == "merge_requests":
+		var found []gitlabMergeRequest
+		for _, mr := range f.MergeRequests {
+			if mr.State == query.Get("state") && mr.TargetBranch == query.Get("target_branch") &&
+				(query.Get("source_branch") == "" || mr.SourceBranch == query.Get("source_branch")) {
+				found = append(found, mr.gitlabMergeRequest)
+			}
+		}
+		start, end, next := f.page(r, len(found))
+		if next > 0 {
+			w.Header().Set("X-Next-Page", strconv.Itoa(next))
+		}
+		writeJSON(w, found[start:end])
+	case r.Method == "POST" && len(path) == 1 && path[0] == "merge_requests":
+		mr := &fakeGitLabMergeRequest{DetailedMergeStatus: "mergeable"}
+		mr.IID = len(f.MergeRequests) + 1
+		mr.State = "opened"
+		mr.Title = fields["title"].(string)
+		mr.Description = fields["description"].(string)
+		mr.SourceBranch = fields["source_branch"].(string)
+		mr.TargetBranch = fields["target_branch"].(string)
+		mr.setReviewers(fields["reviewer_ids"])
+		mr.WebUrl = fmt.Sprintf("https://gitlab.example.com/%s/%s/-/merge_requests/%d", f.Namespace, f.Project, mr.IID)
+		f.MergeRequests = append(f.MergeRequests, mr)
+		w.WriteHeader(http.StatusCreated)
+		writeJSON(w, mr.gitlabMergeRequest)
+	case len(path) >= 2 && path[0] == "merge_requests":
+		mr := f.mergeRequest(path[1])
+		if mr == nil {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.Method == "GET" && len(path) == 2:
+			writeJSON(w, mr)
+		case r.Method == "PUT" && len(path) == 2:
+			if title, ok := fields["title"].(string); ok {
+				mr.Title = title
+			}
+			if description, ok := fields["description"].(string); ok {
+				mr.Description = description
+			}
+			if ids, ok := fields["reviewer_ids"]; ok {
+				mr.setReviewers(ids)
+			}
+			if fields["state_event"] == "close" {
+				mr.State = "closed"
+			}
+			writeJSON(w, mr.gitlabMergeRequest)
+		case r.Method == "POST" && len(path) == 3 && path[2] == "notes":
+			mr.Notes = append(mr.Notes, fields["body"].(string))
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == "PUT" && len(path) == 3 && path[2] == "merge":
+			mr.State = "merged"
+			mr.Squashed = fields["squash"].(bool)
+			writeJSON(w, mr.gitlabMergeRequest)
+		default:
+			writeError(w, http.StatusNotFound)
+		}
+	case r.Method == "POST" && len(path) == 2 && path[0] == "statuses":
+		status := map[string]string{}
+		for k, v := range fields {
+			status[k] = v.(string)
+		}
+		f.Statuses[path[1]] = append(f.Statuses[path[1]], status)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		writeError(w, http.StatusNotFound)
+	}
+}
+
+// setReviewers replaces the reviewers with the reviewer_ids of a request
+func (mr *fakeGitLabMergeRequest) setReviewers(ids interface{}) {
+	mr.Reviewers = nil
+	list, _ := ids.([]interface{})
+	for _, id := range list {
+		mr.Reviewers = append(mr.Reviewers, struct {
+			ID int `json:"id"`
+		}{int(id.(float64))})
+	}
+}
+
+func (f *fakeGitLab) mergeRequest(iid string) *fakeGitLabMergeRequest {
+	n, _ := strconv.Atoi(iid)
+	for _, mr := range f.MergeRequests {
+		if mr.IID == n {
+			return mr
+		}
+	}
+	return nil
+}
+
+func TestGitLabBranches(t *testing.T) {
+	fake, gitlab := newFakeGitLab(t)
+
+	branch, err := gitlab.DefaultBranch(fake.Project)
+	if err != nil || branch != "main" {
+		t.Fatalf("DefaultBranch = %q, %v, want main", branch, err)
+	}
+	exists, err := gitlab.BranchExists(fake.Project, "release/1.0")
+	if err != nil || exists {
+		t.Fatalf("BranchExists(release/1.0) = %v, %v before it was created", exists, err)
+	}
+	err = gitlab.CreateBranch(fake.Project, "release/1.0", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err = gitlab.BranchExists(fake.Project, "release/1.0")
+	if err != nil || !exists {
+		t.Fatalf("BranchExists(release/1.0) = %v, %v after it was created", exists, err)
+	}
+	err = gitlab.CreateBranch(fake.Project, "release/1.1", "develop")
+	if !rest.IsStatus(err, http.StatusBadRequest) {
+		t.Errorf("CreateBranch from a missing start point returned %v", err)
+	}
+}
+
+func TestGitLabMergeRequests(t *testing.T) {
+	fake, gitlab := newFakeGitLab(t)
+
+	pr, err := gitlab.FindPullRequest(fake.Project, "release/1.0", "main")
+	if err != nil || pr != nil {
+		t.Fatalf("FindPullRequest = %v, %v before it was opened", pr, err)
+	}
+	err = gitlab.OpenPullRequest(fake.Project, PullRequest{
+		Title:        "Release 1.0",
+		Description:  "first",
+		SourceBranch: "release/1.0",
+		TargetBranch: "main",
+		Reviewers:    []string{"alice", "@@backend"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err = gitlab.FindPullRequest(fake.Project, "release/1.0", "main")
+	if err != nil || pr == nil {
+		t.Fatalf("FindPullRequest = %v, %v after it was opened", pr, err)
+	}
+	if pr.ID != 1 || pr.Title != "Release 1.0" || pr.Description != "first" || pr.Url == "" {
+		t.Errorf("found %+v", pr)
+	}
+	other, err := gitlab.FindPullRequest(fake.Project, "release/1.0", "develop")
+	if err != nil || other != nil {
+		t.Errorf("FindPullRequest into develop = %v, %v", other, err)
+	}
+
+	pr.Title = "Release 1.0 (updated)"
+	pr.Description = "second"
+	pr.Reviewers = []string{"bob"}
+	err = gitlab.UpdatePullRequest(fake.Project, *pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gitlab.CommentPullRequest(fake.Project, *pr, "updated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := fake.MergeRequests[0]
+	if mr.Title != "Release 1.0 (updated)" || mr.Description != "second" || mr.TargetBranch != "main" {
+		t.Errorf("merge request is %q: %q into %s after the update", mr.Title, mr.Description, mr.TargetBranch)
+	}
+	if len(mr.Reviewers) != 2 || mr.Reviewers[0].ID != 1 || mr.Reviewers[1].ID != 2 {
+		t.Errorf("reviewers are %v, want alice and bob", mr.Reviewers)
+	}
+	pr.Reviewers = []string{"nobody"}
+	if err := gitlab.UpdatePullRequest(fake.Project, *pr); err == nil || !strings.Contains(err.Error(), "reviewer nobody not found") {
+		t.Errorf("UpdatePullRequest with an unknown reviewer returned %v", err)
+	}
+	if strings.Join(mr.Notes, ",") != "updated" {
+		t.Errorf("notes are %v", mr.Notes)
+	}
+}
+
+func TestGitLabListMergeRequests(t *testing.T) {
+	fake, gitlab := newFakeGitLab(t)
+	testListPullRequests(t, &fake.fakeAPI, gitlab, fake.Project)
+}
+
+func TestGitLabReportCommit(t *testing.T) {
+	fake, gitlab := newFakeGitLab(t)
+	err := gitlab.ReportCommit(fake.Project, "abc", CommitReport{Key: reportKey, Passed: true, Summary: "api, web promoted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := fake.Statuses["abc"]
+	if len(statuses) != 1 || statuses[0]["state"] != "success" || statuses[0]["name"] != reportKey || statuses[0]["description"] != "api, web promoted" {
+		t.Fatalf("statuses are %v", statuses)
+	}
+	if _, ok := statuses[0]["target_url"]; ok {
+		t.Errorf("a report without a url set target_url %q", statuses[0]["target_url"])
+	}
+}
+
+func TestGitLabWriteErrors(t *testing.T) {
+	fake, gitlab := newFakeGitLab(t)
+	err := gitlab.OpenPullRequest(fake.Project, PullRequest{Title: "Release 1.0", SourceBranch: "release/1.0", TargetBranch: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := gitlab.FindPullRequest(fake.Project, "release/1.0", "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongNamespace := gitlab
+	wrongNamespace.Namespace = "someone-else"
+	testWriteErrors(t, &fake.fakeAPI, gitlab, wrongNamespace, fake.Project, *pr)
+	if _, ok := fake.Branches["release/1.1"]; ok || len(fake.MergeRequests) != 1 || len(fake.MergeRequests[0].Notes) != 0 || fake.MergeRequests[0].State != "opened" {
+		t.Errorf("a failed write changed the project")
+	}
+}
+
+func TestGitLabMergeStatus(t *testing.T) {
+	fake, gitlab := newFakeGitLab(t)
+	err := gitlab.OpenPullRequest(fake.Project, PullRequest{Title: "Release 1.0", SourceBranch: "release/1.0", TargetBranch: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := PullRequest{ID: 1, SourceBranch: "release/1.0", TargetBranch: "main"}
+	mr := fake.MergeRequests[0]
+
+	for _, c := range []struct {
+		status       string
+		hasConflicts bool
+		mergeable    bool
+		blocked      bool
+	}{
+		{status: "mergeable", mergeable: true},
+		{status: "not_approved"},
+		{status: "ci_still_running"},
+		{status: "discussions_not_resolved", blocked: true},
+		{status: "mergeable", hasConflicts: true, blocked: true},
+	} {
+		mr.DetailedMergeStatus = c.status
+		mr.HasConflicts = c.hasConflicts
+		status, err := gitlab.MergeStatus(fake.Project, pr)
+		if err != nil {
+			t.Fatalf("%s: %v", c.status, err)
+		}
+		if status.Mergeable != c.mergeable || status.Blocked != c.blocked {
+			t.Errorf("%s (conflicts %v): got %+v", c.status, c.hasConflicts, status)
+		}
+	}
+
+	err = gitlab.MergePullRequest(fake.Project, pr, "squash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mr.State != "merged" || !mr.Squashed {
+		t.Errorf("merge request is %s, squashed %v, want merged and squashed", mr.State, mr.Squashed)
+	}
+}
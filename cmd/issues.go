@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/jira"
+)
+
+var issueKey = regexp.MustCompile(`\b[A-Z][A-Z0-9_]+-[1-9][0-9]*\b`)
+
+// Issue is a Jira issue key found in the application commits of a release
+type Issue struct {
+	Key      string
+	Url      string
+	Services []string
+}
+
+// extractIssueKeys returns the issue keys in text, restricted to projects
+// when any are given
+func extractIssueKeys(text string, projects []string) []string {
+	var keys []string
+	for _, key := range issueKey.FindAllString(text, -1) {
+		if len(projects) > 0 && !containsString(projects, key[:strings.LastIndex(key, "-")]) {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CollectIssueKeys lists the issue keys mentioned by the commits in the
+// release's changelogs, with the services they were found in
+func (s PrConfig) CollectIssueKeys() {
+	if s.Release == nil || !s.IssueKeys {
+		return
+	}
+	issues := map[string]*Issue{}
+	for _, changelog := range s.Release.Changelogs {
+		for _, entry := range changelog.Entries {
+			for _, key := range extractIssueKeys(entry.Message, s.IssueProjects) {
+				issue, ok := issues[key]
+				if !ok {
+					issue = &Issue{Key: key}
+					if s.JiraUrl != "" {
+						issue.Url = jira.IssueUrl(s.JiraUrl, key)
+					}
+					issues[key] = issue
+				}
+				if !containsString(issue.Services, changelog.Service) {
+					issue.Services = append(issue.Services, changelog.Service)
+				}
+			}
+		}
+	}
+
+	s.Release.Issues = nil
+	for _, issue := range issues {
+		s.Release.Issues = append(s.Release.Issues, *issue)
+	}
+	sort.Slice(s.Release.Issues, func(i, j int) bool {
+		return s.Release.Issues[i].Key < s.Release.Issues[j].Key
+	})
+	logger.Printf("found %d issue keys", len(s.Release.Issues))
+}
+
+// validJiraOptions checks annotating issues is limited to known projects, the
+// issue key pattern also matches the likes of UTF-8 and SHA-256
+func validJiraOptions(comment bool, transition string, projects []string) error {
+	if (comment || transition != "") && len(projects) == 0 {
+		return fmt.Errorf("--jira-comment and --jira-transition need --issue-projects")
+	}
+	return nil
+}
+
+// jiraClient authenticates with basic auth when a username is found, else
+// with the token alone as a personal access token
+func (s PrConfig) jiraClient() (*jira.Client, error) {
+	creds, err := s.JiraCredentials.Resolve()
+	if err != nil {
+		return nil, fmt.Errorf("jira credentials: %w", err)
+	}
+	return jira.NewClient(s.JiraUrl, creds.RestAuth()), nil
+}
+
+// AnnotateIssues comments on and transitions the issues of a prod release once
+// its pull request is open. A failing issue is logged and skipped, the pull
+// request is already open at this point.
+func (s PrConfig) AnnotateIssues(prUrl string) {
+	if s.Release == nil || s.IsStaging() || (!s.JiraComment && s.JiraTransition == "") {
+		return
+	}
+	if s.JiraUrl == "" {
+		logger.Println("no jira url configured, not annotating issues")
+		return
+	}
+	err := validJiraOptions(s.JiraComment, s.JiraTransition, s.IssueProjects)
+	if err != nil {
+		logger.Printf("not annotating issues: %s", err)
+		return
+	}
+	client, err := s.jiraClient()
+	if err != nil {
+		logger.Printf("not annotating issues: %s", err)
+		return
+	}
+	for _, issue := range s.Release.Issues {
+		if s.JiraComment {
+			text := fmt.Sprintf("Promoted to %s with %s (%s) in release %s: %s", s.Environment(), strings.Join(issue.Services, ", "), s.Product, s.SourceBranch, prUrl)
+			_, err := client.AddComment(issue.Key, text)
+			if err != nil {
+				logger.Printf("commenting on %s: %s", issue.Key, err)
+				continue
+			}
+		}
+		if s.JiraTransition != "" {
+			err := client.TransitionTo(issue.Key, s.JiraTransition)
+			if err != nil {
+				logger.Printf("transitioning %s: %s", issue.Key, err)
+				continue
+			}
+		}
+		logger.Println("annotated issue: ", issue.Key)
+	}
+}
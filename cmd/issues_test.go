@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestExtractIssueKeysProjects checks --issue-projects drops the likes of
+// UTF-8 and SHA-256 the issue key pattern also matches
+func TestExtractIssueKeysProjects(t *testing.T) {
+	message := "ABC-12 read UTF-8 names and hash with SHA-256 (DEF-3)"
+	tests := []struct {
+		projects []string
+		want     []string
+	}{
+		{nil, []string{"ABC-12", "UTF-8", "SHA-256", "DEF-3"}},
+		{[]string{"ABC"}, []string{"ABC-12"}},
+		{[]string{"ABC", "DEF"}, []string{"ABC-12", "DEF-3"}},
+	}
+	for _, tt := range tests {
+		if got := extractIssueKeys(message, tt.projects); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extractIssueKeys with projects %v = %v, want %v", tt.projects, got, tt.want)
+		}
+	}
+}
+
+func TestValidJiraOptions(t *testing.T) {
+	if err := validJiraOptions(true, "", nil); err == nil {
+		t.Error("commenting on issues of any project is allowed")
+	}
+	if err := validJiraOptions(false, "Released", nil); err == nil {
+		t.Error("transitioning issues of any project is allowed")
+	}
+	if err := validJiraOptions(true, "Released", []string{"ABC"}); err != nil {
+		t.Errorf("annotating ABC issues failed: %s", err)
+	}
+	if err := validJiraOptions(false, "", nil); err != nil {
+		t.Errorf("listing issue keys of any project failed: %s", err)
+	}
+}
+
+// TestJiraCredentials checks Jira is authenticated with its own variables,
+// never with the provider credentials
+func TestJiraCredentials(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(envName("jira-url"), "https://jira.example.com")
+	t.Setenv(username, "provider-user")
+	t.Setenv(password, "provider-password")
+	t.Setenv(jiraUsername, "")
+	t.Setenv(jiraToken, "jira-pat")
+	t.Setenv("RELEASE_JIRA_USER", "jira-user")
+	t.Setenv("RELEASE_JIRA_TOKEN", "jira-api-token")
+
+	settings, err := LoadSettings(rootCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := settings.JiraCredentials().Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Auth != authToken || creds.Username != "" || creds.Secret != "jira-pat" {
+		t.Errorf("jira credentials are %s %s / %s, want a jira-pat token", creds.Auth, creds.Username, creds.Secret)
+	}
+
+	t.Setenv(envName("jira-username-env"), "RELEASE_JIRA_USER")
+	t.Setenv(envName("jira-token-env"), "RELEASE_JIRA_TOKEN")
+	settings, err = LoadSettings(rootCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err = settings.JiraCredentials().Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.Auth != authBasic || creds.Username != "jira-user" || creds.Secret != "jira-api-token" {
+		t.Errorf("jira credentials are %s %s / %s, want jira-user / jira-api-token", creds.Auth, creds.Username, creds.Secret)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright © 2022 Tony Prestifilippo
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of the merge command, so a pipeline can tell why it stopped.
+// Errors exit with 1 through log.Fatal.
+const (
+	exitMerged   = 0
+	exitBlocked  = 2
+	exitTimedOut = 3
+)
+
+// MergeConfig waits for the release pull request of SourceBranch to pass its
+// merge checks and merges it
+type MergeConfig struct {
+	PrConfig
+	Strategy     string
+	Timeout      time.Duration
+	PollInterval time.Duration
+}
+
+// Merge polls the merge status until the pull request can be merged, is
+// blocked or Timeout passes, and returns the exit code for the outcome
+func (m MergeConfig) Merge() (int, error) {
+	localRepoSlug := m.SetLocalRepoSlug()
+	deadline := time.Now().Add(m.Timeout)
+	for {
+		pr, err := m.Provider.FindPullRequest(localRepoSlug, m.SourceBranch, m.TargetBranch)
+		if err != nil {
+			return 0, err
+		}
+		if pr == nil {
+			return 0, fmt.Errorf("no open pull request from %s to %s in %s", m.SourceBranch, m.TargetBranch, localRepoSlug)
+		}
+
+		status, err := m.Provider.MergeStatus(localRepoSlug, *pr)
+		if err != nil {
+			return 0, err
+		}
+		if status.Mergeable {
+			err = m.Provider.MergePullRequest(localRepoSlug, *pr, m.Strategy)
+			if err != nil {
+				return 0, err
+			}
+			logger.Printf("pull request %d was merged", pr.ID)
+			return exitMerged, nil
+		}
+		reasons := strings.Join(status.Reasons, "; ")
+		if status.Blocked {
+			logger.Printf("pull request %d is blocked: %s", pr.ID, reasons)
+			return exitBlocked, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			logger.Printf("timed out waiting for pull request %d: %s", pr.ID, reasons)
+			return exitTimedOut, nil
+		}
+		wait := m.PollInterval
+		if wait > remaining {
+			wait = remaining
+		}
+		logger.Printf("pull request %d can't be merged yet (%s), checking again in %s", pr.ID, reasons, wait)
+		time.Sleep(wait)
+	}
+}
+
+// mergeCmd represents the merge command
+var mergeCmd = &cobra.Command{
+	Use:   "merge",
+	Short: "Wait for the release pull request to be approved and built, then merge it",
+	Long: fmt.Sprintf(`Wait for the release pull request to be approved and built, then merge it.
+Exits with %d when merged, %d when the pull request is blocked (conflicts, changes
+requested, failed builds) and %d when the merge checks did not pass in time.`, exitMerged, exitBlocked, exitTimedOut),
+	Run: func(cmd *cobra.Command, args []string) {
+		logger.Println("merge called")
+		repoSlug, _ := cmd.Flags().GetString("repo-slug")
+		bbProject, _ := cmd.Flags().GetString("bitbucket-project")
+		sourceBranch, _ := cmd.Flags().GetString("source-branch")
+		targetBranch, _ := cmd.Flags().GetString("target-branch")
+		strategy, _ := cmd.Flags().GetString("strategy")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		pollInterval, _ := cmd.Flags().GetDuration("poll-interval")
+		settings, err := LoadSettings(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		provider, err := NewProvider(settings.Provider, settings.ProviderOptions(bbProject))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		myMergeConfig := MergeConfig{
+			PrConfig: PrConfig{
+				StagingRepoSlug: repoSlug,
+				BBProject:       bbProject,
+				SourceBranch:    sourceBranch,
+				TargetBranch:    targetBranch,
+				Provider:        provider,
+			},
+			Strategy:     strategy,
+			Timeout:      timeout,
+			PollInterval: pollInterval,
+		}
+		myMergeConfig.PrConfig, err = myMergeConfig.ResolveBranches()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		code, err := myMergeConfig.Merge()
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(code)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+
+	mergeCmd.PersistentFlags().String("repo-slug", "", "The repository slug of the release pull request")
+	mergeCmd.PersistentFlags().String("bitbucket-project", "", "The repository bitbucket project, or the owner/namespace for other providers")
+	mergeCmd.PersistentFlags().String("source-branch", "", "The release branch")
+	mergeCmd.PersistentFlags().String("target-branch", "", "The branch the pull request targets (default is the repository's default branch)")
+	mergeCmd.PersistentFlags().String("strategy", "", "The merge strategy, e.g. no-ff, squash or ff-only on Bitbucket Server (default is the repository's default)")
+	mergeCmd.PersistentFlags().Duration("timeout", 30*time.Minute, "How long to wait for approvals and builds, 0 checks once")
+	mergeCmd.PersistentFlags().Duration("poll-interval", 30*time.Second, "How often to check the merge status")
+}
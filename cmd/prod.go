@@ -1,10 +1,11 @@
 /*
 Copyright © 2022 NAME HERE <EMAIL ADDRESS>
-
 */
 package cmd
 
 import (
+	"log"
+
 	"github.com/spf13/cobra"
 )
 
@@ -22,18 +23,23 @@ to quickly create a Cobra application.`,
 		logger.Println("prod called")
 		stagingRepoSlug, _ := cmd.Flags().GetString("staging-repo-slug")
 		prodRepoSlug, _ := cmd.Flags().GetString("prod-repo-slug")
-		bbProject, _ := cmd.Flags().GetString("bitbucket-project")
-		sourceBranch, _ := cmd.Flags().GetString("source-branch")
-		product, _ := cmd.Flags().GetString("product")
-		services, _ := cmd.Flags().GetStringSlice("services")
-
-		myProdConfig := PrConfig{
-			StagingRepoSlug: stagingRepoSlug,
-			ProdRepoSlug:    prodRepoSlug,
-			BBProject:       bbProject,
-			SourceBranch:    sourceBranch,
-			Product:         product,
-			Services:        services,
+		issueProjects, _ := cmd.Flags().GetStringSlice("issue-projects")
+		jiraComment, _ := cmd.Flags().GetBool("jira-comment")
+		jiraTransition, _ := cmd.Flags().GetString("jira-transition")
+		err := validJiraOptions(jiraComment, jiraTransition, issueProjects)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		myProdConfig := newPrConfig(cmd, environmentProduction)
+		myProdConfig.StagingRepoSlug = stagingRepoSlug
+		myProdConfig.ProdRepoSlug = prodRepoSlug
+		myProdConfig.IssueKeys = myProdConfig.IssueKeys || jiraComment || jiraTransition != ""
+		myProdConfig.JiraComment = jiraComment
+		myProdConfig.JiraTransition = jiraTransition
+		myProdConfig, err = myProdConfig.ResolveBranches()
+		if err != nil {
+			log.Fatal(err)
 		}
 
 		PrepRelease(myProdConfig)
@@ -45,10 +51,9 @@ func init() {
 
 	prodCmd.PersistentFlags().String("staging-repo-slug", "", "The repository slug for staging")
 	prodCmd.PersistentFlags().String("prod-repo-slug", "", "The repository slug for prod")
-	prodCmd.PersistentFlags().String("bitbucket-project", "", "The repository bitbucket project")
-	prodCmd.PersistentFlags().String("source-branch", "", "The branch to create")
-	prodCmd.PersistentFlags().String("product", "", "The product which will also be the top level directory of the repo")
-	prodCmd.PersistentFlags().StringSlice("services", []string{""}, "A list of the services that will be deployed to staging")
+	addReleaseFlags(prodCmd)
+	prodCmd.PersistentFlags().Bool("jira-comment", false, "Comment on the issues with the pull request once it is opened, implies --issue-keys")
+	prodCmd.PersistentFlags().String("jira-transition", "", "Transition the issues once the pull request is opened, by transition or status name, implies --issue-keys")
 
 	// Here you will define your flags and configuration settings.
 
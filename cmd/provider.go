@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/internal/rest"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const (
+	providerBitbucketServer = "bitbucket-server"
+	providerGitHub          = "github"
+	providerGitLab          = "gitlab"
+	providerBitbucketCloud  = "bitbucket-cloud"
+)
+
+// Provider is the SCM host the gitops repositories live on. Every REST call and
+// every clone/push goes through it so the release flow doesn't care which host
+// it is talking to.
+type Provider interface {
+	DefaultBranch(repoSlug string) (string, error)
+	BranchExists(repoSlug string, branch string) (bool, error)
+	CreateBranch(repoSlug string, branch string, startPoint string) error
+	FindPullRequest(repoSlug string, sourceBranch string, targetBranch string) (*PullRequest, error)
+	ListPullRequests(repoSlug string, targetBranch string) ([]PullRequest, error)
+	OpenPullRequest(repoSlug string, pr PullRequest) error
+	UpdatePullRequest(repoSlug string, pr PullRequest) error
+	CommentPullRequest(repoSlug string, pr PullRequest, text string) error
+	DeclinePullRequest(repoSlug string, pr PullRequest) error
+	MergeStatus(repoSlug string, pr PullRequest) (*MergeStatus, error)
+	MergePullRequest(repoSlug string, pr PullRequest, strategy string) error
+	ReportCommit(repoSlug string, commit string, report CommitReport) error
+	CloneURL(repoSlug string) string
+	Auth(cloneUrl string) (transport.AuthMethod, error)
+}
+
+// PullRequest is the provider agnostic description of a release pull request.
+// ID and Version are filled in for pull requests read back from the provider.
+// Reviewers are user names or @@group names, added to the reviewers a pull
+// request already has when it is updated.
+type PullRequest struct {
+	ID           int
+	Version      int
+	Url          string
+	Title        string
+	Description  string
+	SourceBranch string
+	TargetBranch string
+	Reviewers    []string
+}
+
+// MergeStatus says whether a pull request can be merged now. Blocked is set
+// when waiting won't help, e.g. conflicts, a reviewer asking for changes or a
+// failed build. Reasons explain why it can't be merged yet.
+type MergeStatus struct {
+	Mergeable bool
+	Blocked   bool
+	Reasons   []string
+}
+
+// ProviderOptions locate the provider. ApiUrl overrides the provider's default
+// REST endpoint, e.g. for GitHub Enterprise, and CloneUrl is a template with
+// {scheme}, {host}, {project} and {repo} placeholders. Credentials are
+// resolved by the provider when it first needs them.
+type ProviderOptions struct {
+	Project     string
+	ApiUrl      string
+	CloneUrl    string
+	Scheme      string
+	Host        string
+	Credentials CredentialSource
+	SSH         SSHOptions
+}
+
+// NewProvider returns the provider registered under name
+func NewProvider(name string, opts ProviderOptions) (Provider, error) {
+	hosts := strings.NewReplacer("{scheme}", opts.Scheme, "{host}", opts.Host)
+	cloneUrl := hosts.Replace(opts.CloneUrl)
+	ssh := opts.SSH
+	ssh.CloneUrl = hosts.Replace(ssh.CloneUrl)
+	switch name {
+	case providerBitbucketServer, "":
+		return NewBitbucketServer(opts.Project, opts.ApiUrl, cloneUrl, opts.Credentials, ssh), nil
+	case providerGitHub:
+		return NewGitHub(opts.Project, opts.ApiUrl, cloneUrl, opts.Credentials, ssh), nil
+	case providerGitLab:
+		return NewGitLab(opts.Project, opts.ApiUrl, cloneUrl, opts.Credentials, ssh), nil
+	case providerBitbucketCloud:
+		return NewBitbucketCloud(opts.Project, opts.ApiUrl, cloneUrl, opts.Credentials, ssh), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+}
+
+// isNotFound reports whether err is a 404, which lookups read as "not found".
+// Writes treat it as any other error, GitHub answers 404 for a wrong owner or
+// a missing permission too.
+func isNotFound(err error) bool {
+	return rest.IsStatus(err, http.StatusNotFound)
+}
+
+// expandCloneUrl fills the {project} and {repo} placeholders of a clone url template
+func expandCloneUrl(template string, project string, repoSlug string) string {
+	return strings.NewReplacer("{project}", project, "{repo}", repoSlug).Replace(template)
+}
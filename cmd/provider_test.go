@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/internal/rest"
+)
+
+// fakeAPI is what the fake provider APIs share. It serves one repository
+// under Prefix to requests carrying Token in Header and answers 404 for any
+// other owner or repository, as the hosts do for a missing permission.
+// Global lists the paths served outside Prefix, such as GitLab's /users.
+// ReadOnly makes every write a 403. PageSize, when set, caps the per_page of
+// lists, see page. Handle serves the rest, with the path
+// below Prefix (or below / for a global path) split on "/" and the decoded
+// JSON body, also as fields when it is an object.
+type fakeAPI struct {
+	sync.Mutex
+	Prefix   string
+	Global   []string
+	Header   string
+	Token    string
+	ReadOnly bool
+	PageSize int
+	Handle   func(w http.ResponseWriter, r *http.Request, path []string, fields map[string]interface{}, body interface{})
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	if r.Header.Get(f.Header) != f.Token {
+		writeError(w, http.StatusUnauthorized)
+		return
+	}
+	prefix := f.Prefix
+	for _, global := range f.Global {
+		if r.URL.Path == global {
+			prefix = ""
+		}
+	}
+	if r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/") {
+		writeError(w, http.StatusNotFound)
+		return
+	}
+	if r.Method != "GET" && f.ReadOnly {
+		writeError(w, http.StatusForbidden)
+		return
+	}
+	var body interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+	fields, _ := body.(map[string]interface{})
+	path := strings.Split(strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/"), "/")
+	f.Handle(w, r, path, fields, body)
+}
+
+func (f *fakeAPI) setReadOnly(readOnly bool) {
+	f.Lock()
+	defer f.Unlock()
+	f.ReadOnly = readOnly
+}
+
+// page picks the items of a list of n that r asks for with page and
+// per_page. next is the number of the following page, 0 on the last one.
+func (f *fakeAPI) page(r *http.Request, n int) (start int, end int, next int) {
+	size, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+	if size <= 0 {
+		size = 20
+	}
+	if f.PageSize > 0 && f.PageSize < size {
+		size = f.PageSize
+	}
+	number, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if number < 1 {
+		number = 1
+	}
+	start, end = (number-1)*size, number*size
+	if start > n {
+		start = n
+	}
+	if end >= n {
+		return start, n, 0
+	}
+	return start, end, number + 1
+}
+
+// pageUrl is the absolute url of page number of the list r asked for
+func pageUrl(r *http.Request, number int) string {
+	query := r.URL.Query()
+	query.Set("page", strconv.Itoa(number))
+	return fmt.Sprintf("http://%s%s?%s", r.Host, r.URL.Path, query.Encode())
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int) {
+	http.Error(w, fmt.Sprintf(`{"message": %q}`, http.StatusText(status)), status)
+}
+
+// providerWrites calls every write of the Provider interface on repo. pr is an
+// open pull request of release/1.0 into main.
+func providerWrites(p Provider, repo string, pr PullRequest) map[string]error {
+	return map[string]error{
+		"CreateBranch":       p.CreateBranch(repo, "release/1.1", "main"),
+		"OpenPullRequest":    p.OpenPullRequest(repo, pr),
+		"UpdatePullRequest":  p.UpdatePullRequest(repo, pr),
+		"CommentPullRequest": p.CommentPullRequest(repo, pr, "updated"),
+		"DeclinePullRequest": p.DeclinePullRequest(repo, pr),
+		"MergePullRequest":   p.MergePullRequest(repo, pr, ""),
+		"ReportCommit":       p.ReportCommit(repo, "abc", CommitReport{Key: reportKey, Url: "https://ci.example.com/1", Passed: true}),
+	}
+}
+
+// testWriteErrors checks a 404 for a wrong owner and a 403 for a token without
+// write access fail every write rather than read as done. wrongOwner is
+// provider pointed at an owner api doesn't serve.
+func testWriteErrors(t *testing.T, api *fakeAPI, provider Provider, wrongOwner Provider, repo string, pr PullRequest) {
+	for _, c := range []struct {
+		name     string
+		provider Provider
+		readOnly bool
+		status   int
+	}{
+		{"wrong owner", wrongOwner, false, http.StatusNotFound},
+		{"read only", provider, true, http.StatusForbidden},
+	} {
+		api.setReadOnly(c.readOnly)
+		for name, err := range providerWrites(c.provider, repo, pr) {
+			if !rest.IsStatus(err, c.status) {
+				t.Errorf("%s: %s returned %v, want a %d", c.name, name, err, c.status)
+			}
+		}
+	}
+	api.setReadOnly(false)
+}
+
+// testListPullRequests opens pull requests into main and develop, checks the
+// ones into main are listed, one per page, and a declined one no longer is
+func testListPullRequests(t *testing.T, api *fakeAPI, provider Provider, repo string) {
+	api.PageSize = 1
+	for _, pr := range []PullRequest{
+		{Title: "Release 1.0", SourceBranch: "release/1.0", TargetBranch: "main"},
+		{Title: "Release 1.1", SourceBranch: "release/1.1", TargetBranch: "main"},
+		{Title: "Feature", SourceBranch: "feature", TargetBranch: "develop"},
+	} {
+		err := provider.OpenPullRequest(repo, pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	open, err := provider.ListPullRequests(repo, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 2 || open[0].SourceBranch != "release/1.0" || open[1].SourceBranch != "release/1.1" || open[0].ID == 0 || open[0].Url == "" {
+		t.Fatalf("ListPullRequests(main) = %+v", open)
+	}
+	err = provider.DeclinePullRequest(repo, open[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	open, err = provider.ListPullRequests(repo, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(open) != 1 || open[0].SourceBranch != "release/1.1" {
+		t.Errorf("ListPullRequests(main) after declining release/1.0 = %+v", open)
+	}
+}
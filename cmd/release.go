@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"gopkg.in/yaml.v3"
+)
+
+// ServiceRelease is the image tag change of one service
+type ServiceRelease struct {
+	Service     string
+	OldImageTag string
+	NewImageTag string
+	Source      string
+}
+
+// Change is the semver delta from the old to the new image tag
+func (r ServiceRelease) Change() string {
+	return VersionChange(r.OldImageTag, r.NewImageTag)
+}
+
+// Unchanged is set for services in the release whose tag did not move
+func (r ServiceRelease) Unchanged() bool {
+	return r.OldImageTag == r.NewImageTag
+}
+
+// ReleaseReport collects what a run did so it can be described on the pull
+// request. Services compares the release branch with where it was cut from the
+// target branch and also lists the services of this run whose tag didn't move,
+// Updated only holds what this run changed on the release branch and Commits
+// the commits it made there. The templates are the ones found in the gitops
+// repository, if any.
+type ReleaseReport struct {
+	Services            []ServiceRelease
+	Updated             []ServiceRelease
+	Changelogs          []ServiceChangelog
+	Issues              []Issue
+	Reviewers           []string
+	Stale               []StalePullRequest
+	Commits             []plumbing.Hash
+	TitleTemplate       string
+	DescriptionTemplate string
+	ReportError         error
+}
+
+// ArgocdDir is where the environment's argocd config files live
+func (s PrConfig) ArgocdDir() string {
+	if s.IsStaging() {
+		return fmt.Sprintf("%s/.argocd/staging", s.Product)
+	}
+	return fmt.Sprintf("%s/.argocd/production/r2", s.Product)
+}
+
+// AppConfigPath is the config.yaml holding the deployed image tag of service
+func (s PrConfig) AppConfigPath(service string) string {
+	return fmt.Sprintf("%s/%s/config.yaml", s.ArgocdDir(), service)
+}
+
+func (s PrConfig) recordUpdate(service string, oldTag string, newTag string) {
+	if s.Release == nil {
+		return
+	}
+	s.Release.Updated = append(s.Release.Updated, ServiceRelease{Service: service, OldImageTag: oldTag, NewImageTag: newTag})
+}
+
+// appConfigs reads every service config below dir in tree
+func appConfigs(tree *object.Tree, dir string) (map[string]AppConfigFile, error) {
+	configs := map[string]AppConfigFile{}
+	sub, err := tree.Tree(dir)
+	if err == object.ErrDirectoryNotFound {
+		return configs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range sub.Entries {
+		if entry.Mode.IsFile() {
+			continue
+		}
+		file, err := sub.File(path.Join(entry.Name, "config.yaml"))
+		if err == object.ErrFileNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return nil, err
+		}
+		appConfig := AppConfigFile{}
+		err = yaml.Unmarshal([]byte(content), &appConfig)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %s", dir, file.Name, err)
+		}
+		configs[entry.Name] = appConfig
+	}
+	return configs, nil
+}
+
+func branchCommit(r *git.Repository, branch string) (*object.Commit, error) {
+	ref, err := r.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", branch, err)
+	}
+	return r.CommitObject(ref.Hash())
+}
+
+// mergeBase is the newest commit branch and other have in common, where
+// branch was cut from other
+func mergeBase(r *git.Repository, branch string, other string) (*object.Commit, error) {
+	head, err := branchCommit(r, branch)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := branchCommit(r, other)
+	if err != nil {
+		return nil, err
+	}
+	bases, err := head.MergeBase(commit)
+	if err != nil {
+		return nil, fmt.Errorf("merge base of %s and %s: %s", branch, other, err)
+	}
+	if len(bases) == 0 {
+		return nil, fmt.Errorf("%s and %s share no history", branch, other)
+	}
+	return bases[0], nil
+}
+
+func branchTree(r *git.Repository, branch string) (*object.Tree, error) {
+	commit, err := branchCommit(r, branch)
+	if err != nil {
+		return nil, err
+	}
+	return commit.Tree()
+}
+
+// CollectReleaseChanges compares the image tags on the release branch with its
+// merge base with the target branch, so the pull request describes everything
+// the branch promotes and not only what the latest run touched. What landed on
+// the target after the branch was cut isn't part of the release.
+func (s PrConfig) CollectReleaseChanges(r *git.Repository, targetBranch string) error {
+	if s.Release == nil {
+		return nil
+	}
+	source, err := branchTree(r, s.SourceBranch)
+	if err != nil {
+		return err
+	}
+	base, err := mergeBase(r, s.SourceBranch, targetBranch)
+	if err != nil {
+		return err
+	}
+	target, err := base.Tree()
+	if err != nil {
+		return err
+	}
+	newConfigs, err := appConfigs(source, s.ArgocdDir())
+	if err != nil {
+		return err
+	}
+	oldConfigs, err := appConfigs(target, s.ArgocdDir())
+	if err != nil {
+		return err
+	}
+
+	requested := map[string]bool{}
+	for _, service := range s.Services {
+		requested[service] = true
+	}
+	s.Release.Services = nil
+	for service, config := range newConfigs {
+		oldTag := oldConfigs[service].App.ImageTag
+		if oldTag != config.App.ImageTag || requested[service] {
+			s.Release.Services = append(s.Release.Services, ServiceRelease{
+				Service:     service,
+				OldImageTag: oldTag,
+				NewImageTag: config.App.ImageTag,
+				Source:      config.App.Source,
+			})
+		}
+	}
+	sort.Slice(s.Release.Services, func(i, j int) bool {
+		return s.Release.Services[i].Service < s.Release.Services[j].Service
+	})
+	return nil
+}
+
+// UpdateComment summarises what the latest run changed on the release branch
+func (s PrConfig) UpdateComment() string {
+	var lines []string
+	if s.Release != nil {
+		for _, service := range s.Release.Updated {
+			if service.OldImageTag == service.NewImageTag {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("- %s: %s -> %s", service.Service, orNone(service.OldImageTag), service.NewImageTag))
+		}
+	}
+	if len(lines) == 0 {
+		return fmt.Sprintf("Release branch %s was updated, no image tags changed.", s.SourceBranch)
+	}
+	return fmt.Sprintf("Release branch %s was updated:\n%s", s.SourceBranch, strings.Join(lines, "\n"))
+}
+
+// VersionTable renders services as a markdown table for the pull request
+// description
+func VersionTable(services []ServiceRelease) string {
+	if len(services) == 0 {
+		return ""
+	}
+	lines := []string{
+		"| Service | Previous tag | New tag | Change |",
+		"| --- | --- | --- | --- |",
+	}
+	for _, service := range services {
+		change := service.Change()
+		if service.Unchanged() {
+			change = "**" + change + "**"
+		}
+		lines = append(lines, fmt.Sprintf("| %s | %s | %s | %s |", service.Service, orNone(service.OldImageTag), service.NewImageTag, change))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func orNone(tag string) string {
+	if tag == "" {
+		return "none"
+	}
+	return tag
+}
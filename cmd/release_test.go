@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// testRepo is an in-memory gitops repository whose main branch holds the
+// staging app configs of tags, service to image tag
+func testRepo(t *testing.T, product string, tags map[string]string) *git.Repository {
+	r, err := git.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCommit(t, r, "main", product, tags)
+	return r
+}
+
+// testBranch creates branch at the tip of from
+func testBranch(t *testing.T, r *git.Repository, branch string, from string) {
+	commit, err := branchCommit(r, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), commit.Hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testCommit commits the staging app configs of tags on branch
+func testCommit(t *testing.T, r *git.Repository, branch string, product string, tags map[string]string) {
+	wt, err := r.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reference(plumbing.NewBranchReferenceName(branch), false); err == nil {
+		err = wt.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Force: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		err = r.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for service, tag := range tags {
+		path := fmt.Sprintf("%s/.argocd/staging/%s/config.yaml", product, service)
+		err = util.WriteFile(wt.Filesystem, path, []byte(fmt.Sprintf("app:\n  image_tag: %s\n", tag)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = wt.Add(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = wt.Commit("update "+branch, &git.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCollectReleaseChangesFromMergeBase checks a tag bumped on the target
+// after the release branch was cut doesn't show up as a downgrade
+func TestCollectReleaseChangesFromMergeBase(t *testing.T) {
+	r := testRepo(t, "pcoe", map[string]string{"api": "1.0.0", "web": "2.0.0"})
+	testBranch(t, r, "release/1", "main")
+	testCommit(t, r, "release/1", "pcoe", map[string]string{"api": "1.1.0"})
+	testCommit(t, r, "main", "pcoe", map[string]string{"web": "2.1.0"})
+
+	s := PrConfig{Product: "pcoe", SourceBranch: "release/1", TargetBranch: "main", Release: &ReleaseReport{}}
+	err := s.CollectReleaseChanges(r, "main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Release.Services) != 1 {
+		t.Fatalf("services are %+v, want only api", s.Release.Services)
+	}
+	if api := s.Release.Services[0]; api.Service != "api" || api.OldImageTag != "1.0.0" || api.NewImageTag != "1.1.0" {
+		t.Errorf("api is %+v, want 1.0.0 -> 1.1.0", api)
+	}
+}
@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// reportKey identifies the build status and Code Insights report of the tool,
+// posting again replaces the earlier ones
+const reportKey = "auto-release-pr"
+
+// Finding severities, as used by Code Insights annotations
+const (
+	severityHigh   = "HIGH"
+	severityMedium = "MEDIUM"
+	severityLow    = "LOW"
+)
+
+// Finding is something the release validation noticed about a service. HIGH
+// findings fail the report.
+type Finding struct {
+	Service  string
+	Path     string
+	Severity string
+	Message  string
+}
+
+// ReportValue is a titled value shown on the report
+type ReportValue struct {
+	Title string
+	Value string
+}
+
+// CommitReport is the provider agnostic build status and report posted on the
+// pushed release commit. Summary is the one line build status description,
+// Url is where the build status links to, the commit page when empty.
+type CommitReport struct {
+	Key      string
+	Title    string
+	Passed   bool
+	Summary  string
+	Details  string
+	Url      string
+	Data     []ReportValue
+	Findings []Finding
+}
+
+// ValidateRelease checks the tag changes and changelogs of the release
+func (s PrConfig) ValidateRelease() []Finding {
+	var findings []Finding
+	if s.Release == nil {
+		return findings
+	}
+	for _, service := range s.Release.Services {
+		finding := Finding{Service: service.Service, Path: s.AppConfigPath(service.Service)}
+		switch {
+		case service.NewImageTag == "":
+			finding.Severity = severityHigh
+			finding.Message = fmt.Sprintf("%s has no image tag", service.Service)
+		case service.Change() == ChangeDowngrade:
+			finding.Severity = severityHigh
+			finding.Message = fmt.Sprintf("%s goes back from %s to %s", service.Service, service.OldImageTag, service.NewImageTag)
+		case service.Change() == ChangeUnknown:
+			finding.Severity = severityMedium
+			finding.Message = fmt.Sprintf("%s is not a semantic version, can't tell what changed since %s", service.NewImageTag, orNone(service.OldImageTag))
+		case service.Unchanged():
+			finding.Severity = severityLow
+			finding.Message = fmt.Sprintf("%s was requested but %s is already deployed", service.Service, service.NewImageTag)
+		default:
+			continue
+		}
+		findings = append(findings, finding)
+	}
+	for _, changelog := range s.Release.Changelogs {
+		if changelog.Error != "" {
+			findings = append(findings, Finding{
+				Service:  changelog.Service,
+				Path:     s.AppConfigPath(changelog.Service),
+				Severity: severityLow,
+				Message:  fmt.Sprintf("no changelog for %s: %s", changelog.Service, changelog.Error),
+			})
+		}
+	}
+	return findings
+}
+
+// CommitReport summarises the services promoted, the validation findings and
+// the tag changes
+func (s PrConfig) CommitReport() CommitReport {
+	findings := s.ValidateRelease()
+	report := CommitReport{
+		Key:      reportKey,
+		Title:    fmt.Sprintf("Release to %s", s.Environment()),
+		Passed:   true,
+		Url:      s.ReportUrl,
+		Findings: findings,
+	}
+	if report.Url == "" {
+		report.Url = os.Getenv("BUILD_URL")
+	}
+	for _, finding := range findings {
+		if finding.Severity == severityHigh {
+			report.Passed = false
+		}
+	}
+
+	var promoted, changes []string
+	if s.Release != nil {
+		for _, service := range s.Release.Services {
+			if service.Unchanged() {
+				continue
+			}
+			promoted = append(promoted, service.Service)
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s (%s)", service.Service, orNone(service.OldImageTag), service.NewImageTag, service.Change()))
+		}
+	}
+	if report.Passed {
+		report.Summary = fmt.Sprintf("%d services promoted, %d findings", len(promoted), len(findings))
+	} else {
+		report.Summary = fmt.Sprintf("%d services promoted, validation failed", len(promoted))
+	}
+	report.Details = strings.Join(changes, "\n")
+	if report.Details == "" {
+		report.Details = "No image tag changes."
+	}
+	report.Data = []ReportValue{
+		{"Environment", s.Environment()},
+		{"Release branch", s.SourceBranch},
+		{"Services promoted", orNone(strings.Join(promoted, ", "))},
+		{"Findings", fmt.Sprintf("%d", len(findings))},
+	}
+	return report
+}
+
+// truncate cuts text to the limit a status description allows
+func truncate(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit-3]) + "..."
+}
+
+// ReportCommit posts the build status and report on the commit just pushed.
+// It fails when the report can't be posted or the release validation failed.
+func (s PrConfig) ReportCommit(r *git.Repository) error {
+	if !s.Report {
+		return nil
+	}
+	head, err := r.Head()
+	if err != nil {
+		return fmt.Errorf("not reporting on the release commit: %w", err)
+	}
+	report := s.CommitReport()
+	err = s.Provider.ReportCommit(s.SetLocalRepoSlug(), head.Hash().String(), report)
+	if err != nil {
+		return fmt.Errorf("reporting on %s failed: %w", head.Hash(), err)
+	}
+	logger.Printf("reported on %s: %s", head.Hash(), report.Summary)
+	if !report.Passed {
+		return fmt.Errorf("release validation failed: %s", report.Summary)
+	}
+	return nil
+}
+
+// ReportError is why reporting on the release commit failed, nil when it
+// didn't
+func (s PrConfig) ReportError() error {
+	if s.Release == nil {
+		return nil
+	}
+	return s.Release.ReportError
+}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// reportedCommits records the reports posted, any other provider call panics
+type reportedCommits struct {
+	Provider
+	reports *[]CommitReport
+	err     error
+}
+
+func (p reportedCommits) ReportCommit(repoSlug string, commit string, report CommitReport) error {
+	*p.reports = append(*p.reports, report)
+	return p.err
+}
+
+// TestReportCommitFails checks a report that can't be posted or a failed
+// validation fail the run, and that --report off posts nothing
+func TestReportCommitFails(t *testing.T) {
+	r := testRepo(t, "pcoe", map[string]string{"api": "1.0.0"})
+	passing := []ServiceRelease{{Service: "api", OldImageTag: "1.0.0", NewImageTag: "1.1.0"}}
+	downgrade := []ServiceRelease{{Service: "api", OldImageTag: "1.1.0", NewImageTag: "1.0.0"}}
+	tests := []struct {
+		name     string
+		report   bool
+		services []ServiceRelease
+		err      error
+		fails    string
+		posted   int
+	}{
+		{"off", false, downgrade, nil, "", 0},
+		{"passed", true, passing, nil, "", 1},
+		{"validation failed", true, downgrade, nil, "release validation failed", 1},
+		{"not posted", true, passing, errors.New("forbidden"), "forbidden", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reports []CommitReport
+			s := PrConfig{
+				StagingRepoSlug: "gitops",
+				Product:         "pcoe",
+				Report:          tt.report,
+				Release:         &ReleaseReport{Services: tt.services},
+				Provider:        reportedCommits{reports: &reports, err: tt.err},
+			}
+			err := s.ReportCommit(r)
+			switch {
+			case tt.fails == "" && err != nil:
+				t.Errorf("ReportCommit failed: %s", err)
+			case tt.fails != "" && (err == nil || !strings.Contains(err.Error(), tt.fails)):
+				t.Errorf("ReportCommit = %v, want an error with %q", err, tt.fails)
+			}
+			if len(reports) != tt.posted {
+				t.Errorf("posted %d reports, want %d", len(reports), tt.posted)
+			}
+		})
+	}
+}
+
+// TestBitbucketServerWebUrl checks the report link keeps the scheme, host and
+// context path of the API url, whatever its REST path
+func TestBitbucketServerWebUrl(t *testing.T) {
+	tests := map[string]string{
+		"https://bitbucket.example.com/rest/api/1.0":           "https://bitbucket.example.com",
+		"https://bitbucket.example.com/rest/api/latest/":       "https://bitbucket.example.com",
+		"https://example.com/bitbucket/rest/api/1.0":           "https://example.com/bitbucket",
+		"http://localhost:7990/rest/api/1.0?ignored=parameter": "http://localhost:7990",
+	}
+	for apiUrl, want := range tests {
+		b := NewBitbucketServer("ACME", apiUrl, "", CredentialSource{}, SSHOptions{})
+		if got := b.webUrl(); got != want {
+			t.Errorf("webUrl of %s = %s, want %s", apiUrl, got, want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+const (
+	defaultReviewersFile = ".auto-release-pr/reviewers"
+	reviewerGroupPrefix  = "@@"
+)
+
+// ReviewerRule is one line of the reviewers file: a path pattern and the
+// users, or @@groups, owning what matches it
+type ReviewerRule struct {
+	Pattern   string
+	Reviewers []string
+}
+
+// Matches reports whether the pattern matches name or one of its parent
+// directories. Patterns are path.Match globs relative to the repository root.
+func (r ReviewerRule) Matches(name string) bool {
+	pattern := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(r.Pattern, "/"), "/**"), "/")
+	if pattern == "" || pattern == "**" {
+		return true
+	}
+	for p := name; p != "." && p != "/" && p != ""; p = path.Dir(p) {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseReviewers reads a CODEOWNERS style file: a pattern followed by its
+// reviewers on each line, # starts a comment. Users may be written as @user or
+// user, groups as @@group.
+func ParseReviewers(content string) ([]ReviewerRule, error) {
+	var rules []ReviewerRule
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) == 1 {
+			return nil, fmt.Errorf("line %d: %s has no reviewers", n, fields[0])
+		}
+		rule := ReviewerRule{Pattern: fields[0]}
+		for _, reviewer := range fields[1:] {
+			if !strings.HasPrefix(reviewer, reviewerGroupPrefix) {
+				reviewer = strings.TrimPrefix(reviewer, "@")
+			}
+			rule.Reviewers = append(rule.Reviewers, reviewer)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// ServicePath is the directory of service in the gitops repository
+func (s PrConfig) ServicePath(service string) string {
+	return fmt.Sprintf("%s/services/%s", s.Product, service)
+}
+
+// loadReviewerRules reads the reviewers file from the gitops checkout, if
+// there is one
+func (s PrConfig) loadReviewerRules(fs billy.Filesystem) ([]ReviewerRule, error) {
+	name := s.ReviewersFile
+	if name == "" {
+		name = defaultReviewersFile
+	}
+	f, err := fs.Open(name)
+	if os.IsNotExist(err) && s.ReviewersFile == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	logger.Println("using reviewers file: ", name)
+	rules, err := ParseReviewers(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return rules, nil
+}
+
+// ResolveReviewers combines the reviewers given on the command line with the
+// owners of every service in the release, taken from the reviewers file in the
+// gitops checkout. Like CODEOWNERS the last matching line wins.
+func (s PrConfig) ResolveReviewers(fs billy.Filesystem) error {
+	if s.Release == nil {
+		return nil
+	}
+	rules, err := s.loadReviewerRules(fs)
+	if err != nil {
+		return err
+	}
+	reviewers := append([]string(nil), s.Reviewers...)
+	for _, service := range s.Release.Services {
+		for i := len(rules) - 1; i >= 0; i-- {
+			if rules[i].Matches(s.ServicePath(service.Service)) {
+				reviewers = append(reviewers, rules[i].Reviewers...)
+				break
+			}
+		}
+	}
+
+	s.Release.Reviewers = nil
+	for _, reviewer := range reviewers {
+		if reviewer != "" && !containsString(s.Release.Reviewers, reviewer) {
+			s.Release.Reviewers = append(s.Release.Reviewers, reviewer)
+		}
+	}
+	logger.Println("reviewers: ", s.Release.Reviewers)
+	return nil
+}
+
+// ReleaseReviewers are the resolved reviewers, or the ones given on the
+// command line when the release branch was not checked out
+func (s PrConfig) ReleaseReviewers() []string {
+	if s.Release == nil {
+		return s.Reviewers
+	}
+	return s.Release.Reviewers
+}
+
+// splitReviewers separates users from @@groups, dropping self, the user the
+// provider authenticates as, since nobody can review their own pull request
+func splitReviewers(reviewers []string, self string) (users []string, groups []string) {
+	for _, reviewer := range reviewers {
+		if strings.HasPrefix(reviewer, reviewerGroupPrefix) {
+			groups = append(groups, strings.TrimPrefix(reviewer, reviewerGroupPrefix))
+		} else if reviewer != self {
+			users = append(users, reviewer)
+		}
+	}
+	return users, groups
+}
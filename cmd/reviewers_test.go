@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// TestReviewersPerEnvironment checks staging and prod release pull requests
+// get the reviewers of their own environment on top of the shared ones
+func TestReviewersPerEnvironment(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(envName("default-reviewers"), "release-manager")
+	t.Setenv(envName("staging-reviewers"), "qa-lead, @@qa")
+	t.Setenv(envName("production-reviewers"), "@@change-board")
+	settings, err := LoadSettings(rootCmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := memfs.New()
+	err = util.WriteFile(fs, defaultReviewersFile, []byte("pcoe/services/api @alice\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		environment string
+		s           PrConfig
+		want        []string
+	}{
+		{environmentStaging, PrConfig{StagingRepoSlug: "gitops-nonprod"}, []string{"release-manager", "qa-lead", "@@qa", "bob", "alice"}},
+		{environmentProduction, PrConfig{StagingRepoSlug: "gitops-nonprod", ProdRepoSlug: "gitops-prod"}, []string{"release-manager", "@@change-board", "bob", "alice"}},
+	}
+	for _, tt := range tests {
+		s := tt.s
+		s.Product = "pcoe"
+		s.Reviewers = settings.Reviewers(tt.environment, []string{"bob"})
+		s.Release = &ReleaseReport{Services: []ServiceRelease{{Service: "api"}}}
+		if s.Environment() != tt.environment {
+			t.Fatalf("%+v is a %s release, want %s", s, s.Environment(), tt.environment)
+		}
+		err = s.ResolveReviewers(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(s.Release.Reviewers, tt.want) {
+			t.Errorf("%s reviewers are %v, want %v", tt.environment, s.Release.Reviewers, tt.want)
+		}
+	}
+}
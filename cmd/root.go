@@ -6,6 +6,7 @@ package cmd
 
 import (
 	"os"
+	"strconv"
 
 	"github.com/spf13/cobra"
 )
@@ -39,7 +40,42 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.auto-release-pr.yaml)")
+	rootCmd.PersistentFlags().String("config", "", "config file (default is $HOME/.auto-release-pr.yaml)")
+	rootCmd.PersistentFlags().String("provider", providerBitbucketServer, "The SCM provider hosting the gitops repositories (bitbucket-server, bitbucket-cloud, github, gitlab)")
+	rootCmd.PersistentFlags().String("scheme", "https", "The scheme used to reach the Bitbucket Server host")
+	rootCmd.PersistentFlags().String("host", defaultBitbucketHost, "The Bitbucket Server host")
+	rootCmd.PersistentFlags().String("api-path", defaultBitbucketApiPath, "The Bitbucket Server REST API path on the host")
+	rootCmd.PersistentFlags().String("api-url", "", "The provider REST API base url, overrides scheme, host and api-path, e.g. https://github.example.com/api/v3 for GitHub Enterprise")
+	rootCmd.PersistentFlags().String("clone-url", "", "The clone url template using {scheme}, {host}, {project} and {repo} (Bitbucket Server default is "+defaultBitbucketCloneUrl+")")
+	rootCmd.PersistentFlags().String("default-reviewers", "", "Comma separated users and @@groups to add as reviewers to every release pull request")
+	rootCmd.PersistentFlags().String("staging-reviewers", "", "Comma separated users and @@groups to add as reviewers to staging release pull requests")
+	rootCmd.PersistentFlags().String("production-reviewers", "", "Comma separated users and @@groups to add as reviewers to prod release pull requests")
+	rootCmd.PersistentFlags().String("auth", "", "How to authenticate against the provider: basic (username and password) or token (access token), default basic when the username variable is set")
+	rootCmd.PersistentFlags().String("username-env", username, "The environment variable holding the username")
+	rootCmd.PersistentFlags().String("password-env", password, "The environment variable holding the password or access token")
+	rootCmd.PersistentFlags().String("username", "", "The username, looked up in the username variable, git credential helpers, ~/.git-credentials, ~/.netrc and the secrets dir when empty")
+	rootCmd.PersistentFlags().String("password", "", "The password or access token, prefer the password variable or a credential store as flags show up in the process list")
+	rootCmd.PersistentFlags().String("credential-command", "", "A command printing {\"username\", \"token\", \"expiry\"} JSON, run instead of looking up the credentials and again when the token expires")
+	rootCmd.PersistentFlags().String("secrets-dir", defaultSecretsDir, "The directory holding username and password files, e.g. a mounted Kubernetes basic-auth secret")
+	rootCmd.PersistentFlags().String("ssh-repos", "", "Comma separated repositories to clone, fetch and push over SSH, * for all of them")
+	rootCmd.PersistentFlags().String("ssh-clone-url", "", "The ssh clone url template using {host}, {project} and {repo} (Bitbucket Server default is "+defaultBitbucketSSHUrl+", else derived from the https clone url)")
+	rootCmd.PersistentFlags().String("ssh-user", defaultSSHUser, "The ssh user when the ssh clone url names none")
+	rootCmd.PersistentFlags().String("ssh-key", "", "The private key file for SSH, the ssh-agent is used when empty")
+	rootCmd.PersistentFlags().String("ssh-key-passphrase-env", defaultSSHPassphraseEnv, "The environment variable holding the passphrase of the private key")
+	rootCmd.PersistentFlags().String("known-hosts", "", "The known_hosts file the server key is verified against (default $SSH_KNOWN_HOSTS or ~/.ssh/known_hosts)")
+	rootCmd.PersistentFlags().String("sign", "", "Sign the release commits: openpgp or ssh, verified before they are pushed")
+	rootCmd.PersistentFlags().String("signing-key", "", "The armored OpenPGP secret key or the SSH private key the commits are signed with")
+	rootCmd.PersistentFlags().String("signing-key-passphrase-env", defaultSigningPassphraseEnv, "The environment variable holding the passphrase of the signing key")
+	rootCmd.PersistentFlags().String("author-name", "", "The author name of the release commits (default is the git config user)")
+	rootCmd.PersistentFlags().String("author-email", "", "The author email of the release commits")
+	rootCmd.PersistentFlags().String("committer-name", "", "The committer name of the release commits (default is the author)")
+	rootCmd.PersistentFlags().String("committer-email", "", "The committer email of the release commits")
+	rootCmd.PersistentFlags().String("commit-template", "", "Go text/template for the image tag update commit messages, with .Service, .Environment, .OldImageTag, .NewImageTag, .Change and .CI")
+	rootCmd.PersistentFlags().String("fetch-depth", strconv.Itoa(defaultFetchDepth), "The depth the gitops repository is cloned at, deepened until the release branch shares history with the target branch, 0 for the full history. Application repositories are cloned as deep for the changelog")
+	rootCmd.PersistentFlags().String("jira-url", "", "The Jira base url issue keys are linked to and annotated on, e.g. https://jira.example.com")
+	rootCmd.PersistentFlags().String("jira-username-env", jiraUsername, "The environment variable holding the Jira username, a token without a username is sent as a personal access token")
+	rootCmd.PersistentFlags().String("jira-token-env", jiraToken, "The environment variable holding the Jira API or personal access token")
+	rootCmd.PersistentFlags().String("jira-secrets-dir", defaultJiraSecretsDir, "The directory holding the Jira username and password files")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
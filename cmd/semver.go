@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Semver is the release part of an image tag, e.g. 1.2.0 or 1.2.0-rc.1
+type Semver struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+}
+
+// ParseSemver parses major.minor.patch with an optional -prerelease, a leading
+// v is ignored
+func ParseSemver(version string) (Semver, error) {
+	var v Semver
+	core := strings.TrimPrefix(version, "v")
+	if i := strings.Index(core, "-"); i >= 0 {
+		core, v.Prerelease = core[:i], core[i+1:]
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("%q is not a semantic version", version)
+	}
+	for i, dst := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return v, fmt.Errorf("%q is not a semantic version", version)
+		}
+		*dst = n
+	}
+	return v, nil
+}
+
+// SplitImageTag splits an image tag written by UpdateVersionFiles, which is
+// <release>-<commit hash>, into its release and commit hash
+func SplitImageTag(tag string) (release string, commitHash string) {
+	i := strings.LastIndex(tag, "-")
+	if i < 0 {
+		return tag, ""
+	}
+	return tag[:i], tag[i+1:]
+}
+
+// Version change kinds reported for a service
+const (
+	ChangeUnchanged  = "unchanged"
+	ChangeNew        = "new"
+	ChangeMajor      = "major"
+	ChangeMinor      = "minor"
+	ChangePatch      = "patch"
+	ChangePrerelease = "prerelease"
+	ChangeRebuild    = "rebuild"
+	ChangeDowngrade  = "downgrade"
+	ChangeUnknown    = "unknown"
+)
+
+// VersionChange classifies the move from one image tag to another
+func VersionChange(oldTag string, newTag string) string {
+	if oldTag == newTag {
+		return ChangeUnchanged
+	}
+	if oldTag == "" {
+		return ChangeNew
+	}
+	oldRelease, _ := SplitImageTag(oldTag)
+	newRelease, _ := SplitImageTag(newTag)
+	oldVersion, err := ParseSemver(oldRelease)
+	if err != nil {
+		return ChangeUnknown
+	}
+	newVersion, err := ParseSemver(newRelease)
+	if err != nil {
+		return ChangeUnknown
+	}
+
+	switch compareSemver(newVersion, oldVersion) {
+	case -1:
+		return ChangeDowngrade
+	case 0:
+		return ChangeRebuild
+	}
+	switch {
+	case newVersion.Major != oldVersion.Major:
+		return ChangeMajor
+	case newVersion.Minor != oldVersion.Minor:
+		return ChangeMinor
+	case newVersion.Patch != oldVersion.Patch:
+		return ChangePatch
+	default:
+		return ChangePrerelease
+	}
+}
+
+// compareSemver orders versions by precedence, see comparePrerelease for the
+// prerelease part
+func compareSemver(a Semver, b Semver) int {
+	for _, d := range []int{a.Major - b.Major, a.Minor - b.Minor, a.Patch - b.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	switch {
+	case a.Prerelease == b.Prerelease:
+		return 0
+	case a.Prerelease == "":
+		return 1
+	case b.Prerelease == "":
+		return -1
+	}
+	return comparePrerelease(a.Prerelease, b.Prerelease)
+}
+
+// comparePrerelease compares dot separated prerelease identifiers as semver
+// 2.0.0 §11 does: numeric identifiers numerically and below alphanumeric
+// ones, others as strings, and a prefix of more identifiers first. rc.9
+// precedes rc.10 and alpha precedes alpha.1.
+func comparePrerelease(a string, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.ParseUint(as[i], 10, 64)
+		bn, bErr := strconv.ParseUint(bs[i], 10, 64)
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				return ordering(an < bn)
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		case as[i] != bs[i]:
+			return ordering(as[i] < bs[i])
+		}
+	}
+	switch {
+	case len(as) == len(bs):
+		return 0
+	case len(as) < len(bs):
+		return -1
+	default:
+		return 1
+	}
+}
+
+// ordering is -1 when less holds and 1 otherwise
+func ordering(less bool) int {
+	if less {
+		return -1
+	}
+	return 1
+}
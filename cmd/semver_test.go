@@ -0,0 +1,51 @@
+package cmd
+
+import "testing"
+
+func TestCompareSemver(t *testing.T) {
+	for _, c := range []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0-rc.9", "1.0.0-rc.10", -1},
+		{"1.0.0-rc.10", "1.0.0-rc.9", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha", 1},
+		{"1.0.0-rc", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc", 1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0-rc.1", 0},
+		{"1.0.0", "1.0.1-rc.1", -1},
+		{"2.0.0", "1.10.0", 1},
+	} {
+		a, err := ParseSemver(c.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseSemver(c.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := compareSemver(a, b); got != c.want {
+			t.Errorf("compareSemver(%s, %s) = %d, want %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+func TestVersionChangePrerelease(t *testing.T) {
+	for _, c := range []struct {
+		old, new string
+		want     string
+	}{
+		{"1.0.0-rc.9-abc1234", "1.0.0-rc.10-def5678", ChangePrerelease},
+		{"1.0.0-rc.10-abc1234", "1.0.0-rc.9-def5678", ChangeDowngrade},
+		{"1.0.0-rc-abc1234", "1.0.0-def5678", ChangePrerelease},
+		{"1.0.0-abc1234", "1.0.0-rc-def5678", ChangeDowngrade},
+	} {
+		if got := VersionChange(c.old, c.new); got != c.want {
+			t.Errorf("VersionChange(%s, %s) = %s, want %s", c.old, c.new, got, c.want)
+		}
+	}
+}
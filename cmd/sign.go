@@ -0,0 +1,335 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"golang.org/x/crypto/ssh"
+)
+
+// Commit signature formats, as in git's gpg.format
+const (
+	signOpenPGP                 = "openpgp"
+	signSSH                     = "ssh"
+	defaultSigningPassphraseEnv = "SIGNING_KEY_PASSPHRASE"
+)
+
+// SSH signatures follow the sshsig format of ssh-keygen -Y sign, in the git
+// namespace git verify-commit checks
+const (
+	sshSigMagic     = "SSHSIG"
+	sshSigVersion   = 1
+	sshSigNamespace = "git"
+	sshSigHash      = "sha512"
+	sshSigArmorHead = "-----BEGIN SSH SIGNATURE-----"
+	sshSigArmorTail = "-----END SSH SIGNATURE-----"
+)
+
+// SigningOptions sign the promotion commits. Format is openpgp or ssh, empty
+// for unsigned commits. KeyFile is the armored OpenPGP secret key or the SSH
+// private key, its passphrase is read from PassphraseEnv.
+type SigningOptions struct {
+	Format        string
+	KeyFile       string
+	PassphraseEnv string
+}
+
+func validSigningFormat(format string) error {
+	switch format {
+	case "", signOpenPGP, signSSH:
+		return nil
+	}
+	return fmt.Errorf("unknown signing format %q, expected %s or %s", format, signOpenPGP, signSSH)
+}
+
+func (o SigningOptions) passphrase() []byte {
+	env := o.PassphraseEnv
+	if env == "" {
+		env = defaultSigningPassphraseEnv
+	}
+	return []byte(os.Getenv(env))
+}
+
+// openPGPKey reads the secret key, decrypting it and its subkeys when they
+// are protected by a passphrase
+func (o SigningOptions) openPGPKey() (*openpgp.Entity, error) {
+	f, err := os.Open(o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keyring, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading OpenPGP key %s: %s", o.KeyFile, err)
+	}
+	if len(keyring) == 0 || keyring[0].PrivateKey == nil {
+		return nil, fmt.Errorf("%s holds no OpenPGP secret key", o.KeyFile)
+	}
+	entity := keyring[0]
+	if entity.PrivateKey.Encrypted {
+		err = entity.PrivateKey.Decrypt(o.passphrase())
+		if err != nil {
+			return nil, fmt.Errorf("decrypting OpenPGP key %s: %s", o.KeyFile, err)
+		}
+	}
+	for _, subkey := range entity.Subkeys {
+		if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+			err = subkey.PrivateKey.Decrypt(o.passphrase())
+			if err != nil {
+				return nil, fmt.Errorf("decrypting OpenPGP subkey of %s: %s", o.KeyFile, err)
+			}
+		}
+	}
+	return entity, nil
+}
+
+func (o SigningOptions) sshKey() (ssh.Signer, error) {
+	data, err := ioutil.ReadFile(o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, o.passphrase())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading ssh signing key %s: %s", o.KeyFile, err)
+	}
+	return signer, nil
+}
+
+// sshSignHead rewrites the commit just made with an SSH signature, go-git
+// only signs with OpenPGP keys, and moves the checked out branch to it
+func (o SigningOptions) sshSignHead(r *git.Repository, hash plumbing.Hash) (plumbing.Hash, error) {
+	signer, err := o.sshKey()
+	if err != nil {
+		return hash, err
+	}
+	commit, err := r.CommitObject(hash)
+	if err != nil {
+		return hash, err
+	}
+	payload, err := commitPayload(commit)
+	if err != nil {
+		return hash, err
+	}
+	commit.PGPSignature, err = sshSign(signer, payload)
+	if err != nil {
+		return hash, err
+	}
+	signed := r.Storer.NewEncodedObject()
+	err = commit.Encode(signed)
+	if err != nil {
+		return hash, err
+	}
+	hash, err = r.Storer.SetEncodedObject(signed)
+	if err != nil {
+		return hash, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return hash, err
+	}
+	return hash, r.Storer.SetReference(plumbing.NewHashReference(head.Name(), hash))
+}
+
+// commitPayload is the commit as signed, without its signature header
+func commitPayload(commit *object.Commit) ([]byte, error) {
+	encoded := &plumbing.MemoryObject{}
+	err := commit.EncodeWithoutSignature(encoded)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := encoded.Reader()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+// sshSignedData is what the key signs: the namespace and a hash of the message
+type sshSignedData struct {
+	Namespace string
+	Reserved  string
+	Hash      string
+	Digest    []byte
+}
+
+type sshSignatureBlob struct {
+	Version   uint32
+	PublicKey []byte
+	Namespace string
+	Reserved  string
+	Hash      string
+	Signature []byte
+}
+
+func sshDigest(algorithm string, message []byte) ([]byte, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "sha512":
+		h = sha512.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return nil, fmt.Errorf("unsupported ssh signature hash %q", algorithm)
+	}
+	h.Write(message)
+	return h.Sum(nil), nil
+}
+
+// sshSign returns the armored signature of message. RSA keys sign with
+// rsa-sha2-512, as ssh-keygen does.
+func sshSign(signer ssh.Signer, message []byte) (string, error) {
+	digest, err := sshDigest(sshSigHash, message)
+	if err != nil {
+		return "", err
+	}
+	data := append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{Namespace: sshSigNamespace, Hash: sshSigHash, Digest: digest})...)
+	var signature *ssh.Signature
+	if algorithmSigner, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, data, ssh.SigAlgoRSASHA2512)
+	} else {
+		signature, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return "", err
+	}
+	blob := append([]byte(sshSigMagic), ssh.Marshal(sshSignatureBlob{
+		Version:   sshSigVersion,
+		PublicKey: signer.PublicKey().Marshal(),
+		Namespace: sshSigNamespace,
+		Hash:      sshSigHash,
+		Signature: ssh.Marshal(signature),
+	})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var armored strings.Builder
+	armored.WriteString(sshSigArmorHead + "\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n" + sshSigArmorTail + "\n")
+	return armored.String(), nil
+}
+
+// sshVerify checks the armored signature of message was made by key
+func sshVerify(key ssh.PublicKey, message []byte, armored string) error {
+	body := strings.TrimSpace(armored)
+	if !strings.HasPrefix(body, sshSigArmorHead) || !strings.HasSuffix(body, sshSigArmorTail) {
+		return errors.New("not an SSH signature")
+	}
+	body = strings.TrimSuffix(strings.TrimPrefix(body, sshSigArmorHead), sshSigArmorTail)
+	raw, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(body), ""))
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(raw, []byte(sshSigMagic)) {
+		return errors.New("not an SSH signature")
+	}
+	var blob sshSignatureBlob
+	err = ssh.Unmarshal(raw[len(sshSigMagic):], &blob)
+	if err != nil {
+		return err
+	}
+	if blob.Version != sshSigVersion || blob.Namespace != sshSigNamespace {
+		return fmt.Errorf("unexpected SSH signature version %d in namespace %q", blob.Version, blob.Namespace)
+	}
+	if !bytes.Equal(blob.PublicKey, key.Marshal()) {
+		return errors.New("signed by another key")
+	}
+	var signature ssh.Signature
+	err = ssh.Unmarshal(blob.Signature, &signature)
+	if err != nil {
+		return err
+	}
+	digest, err := sshDigest(blob.Hash, message)
+	if err != nil {
+		return err
+	}
+	data := append([]byte(sshSigMagic), ssh.Marshal(sshSignedData{Namespace: blob.Namespace, Hash: blob.Hash, Digest: digest})...)
+	return key.Verify(data, &signature)
+}
+
+// armoredPublicKey is the public half of entity, as Commit.Verify takes it
+func armoredPublicKey(entity *openpgp.Entity) (string, error) {
+	var buf bytes.Buffer
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return "", err
+	}
+	err = entity.Serialize(w)
+	if err != nil {
+		return "", err
+	}
+	err = w.Close()
+	return buf.String(), err
+}
+
+// VerifyCommits checks the signature of every commit this run made against the
+// signing key before anything is pushed, so a broken key or signature fails
+// here rather than on the server's signed commit check
+func (s PrConfig) VerifyCommits(r *git.Repository) error {
+	if s.Signing.Format == "" || s.Release == nil {
+		return nil
+	}
+	var verify func(commit *object.Commit) error
+	switch s.Signing.Format {
+	case signOpenPGP:
+		key, err := s.Signing.openPGPKey()
+		if err != nil {
+			return err
+		}
+		keyring, err := armoredPublicKey(key)
+		if err != nil {
+			return err
+		}
+		verify = func(commit *object.Commit) error {
+			_, err := commit.Verify(keyring)
+			return err
+		}
+	case signSSH:
+		key, err := s.Signing.sshKey()
+		if err != nil {
+			return err
+		}
+		verify = func(commit *object.Commit) error {
+			payload, err := commitPayload(commit)
+			if err != nil {
+				return err
+			}
+			return sshVerify(key.PublicKey(), payload, commit.PGPSignature)
+		}
+	}
+	for _, hash := range s.Release.Commits {
+		commit, err := r.CommitObject(hash)
+		if err != nil {
+			return err
+		}
+		if commit.PGPSignature == "" {
+			return fmt.Errorf("commit %s is not signed", hash)
+		}
+		err = verify(commit)
+		if err != nil {
+			return fmt.Errorf("verifying the %s signature of commit %s: %s", s.Signing.Format, hash, err)
+		}
+	}
+	logger.Printf("verified the %s signatures of %d commits", s.Signing.Format, len(s.Release.Commits))
+	return nil
+}
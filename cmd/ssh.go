@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+const (
+	defaultSSHUser          = "git"
+	defaultSSHPassphraseEnv = "SSH_KEY_PASSPHRASE"
+)
+
+// SSHOptions configure clones, fetches and pushes over SSH. Repos lists the
+// gitops repositories cloned over SSH, * for all of them, and CloneUrl is the
+// ssh clone url template. Without KeyFile the keys of the ssh-agent are used.
+// KnownHosts defaults to $SSH_KNOWN_HOSTS or ~/.ssh/known_hosts.
+type SSHOptions struct {
+	Repos         []string
+	CloneUrl      string
+	User          string
+	KeyFile       string
+	PassphraseEnv string
+	KnownHosts    string
+}
+
+// Enabled says whether repoSlug is cloned over SSH
+func (o SSHOptions) Enabled(repoSlug string) bool {
+	return containsString(o.Repos, "*") || containsString(o.Repos, repoSlug)
+}
+
+// CloneURL returns the ssh clone url of repoSlug when it is cloned over SSH
+// and httpsUrl otherwise. Without a CloneUrl template the ssh url is derived
+// from httpsUrl, which works for hosts serving both on the same path.
+func (o SSHOptions) CloneURL(repoSlug string, project string, httpsUrl string) string {
+	if !o.Enabled(repoSlug) {
+		return httpsUrl
+	}
+	if o.CloneUrl != "" {
+		return expandCloneUrl(o.CloneUrl, project, repoSlug)
+	}
+	u, err := url.Parse(httpsUrl)
+	if err != nil || u.Host == "" {
+		return httpsUrl
+	}
+	return fmt.Sprintf("ssh://%s@%s%s", o.user(), u.Hostname(), u.Path)
+}
+
+func (o SSHOptions) user() string {
+	if o.User == "" {
+		return defaultSSHUser
+	}
+	return o.User
+}
+
+// Auth loads the private key, or connects to the ssh-agent, and the known
+// hosts the server key is verified against. The user comes from cloneUrl when
+// it names one.
+func (o SSHOptions) Auth(cloneUrl string) (transport.AuthMethod, error) {
+	user := o.user()
+	if endpoint, err := transport.NewEndpoint(cloneUrl); err == nil && endpoint.User != "" {
+		user = endpoint.User
+	}
+	var knownHosts []string
+	if o.KnownHosts != "" {
+		knownHosts = append(knownHosts, o.KnownHosts)
+	}
+	callback, err := ssh.NewKnownHostsCallback(knownHosts...)
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts: %s", err)
+	}
+
+	if o.KeyFile == "" {
+		agent, err := ssh.NewSSHAgentAuth(user)
+		if err != nil {
+			return nil, fmt.Errorf("no ssh key given and no ssh-agent available: %s", err)
+		}
+		agent.HostKeyCallback = callback
+		return agent, nil
+	}
+	passphraseEnv := o.PassphraseEnv
+	if passphraseEnv == "" {
+		passphraseEnv = defaultSSHPassphraseEnv
+	}
+	keys, err := ssh.NewPublicKeysFromFile(user, o.KeyFile, os.Getenv(passphraseEnv))
+	if err != nil {
+		return nil, fmt.Errorf("reading ssh key %s (passphrase from %s): %s", o.KeyFile, passphraseEnv, err)
+	}
+	keys.HostKeyCallback = callback
+	return keys, nil
+}
+
+// isSSHUrl tells ssh:// and scp-like user@host:path urls from http(s) and
+// file urls
+func isSSHUrl(cloneUrl string) bool {
+	endpoint, err := transport.NewEndpoint(cloneUrl)
+	return err == nil && strings.HasPrefix(endpoint.Protocol, "ssh")
+}
@@ -5,6 +5,8 @@ Copyright © 2022 Tony Prestifilippo
 package cmd
 
 import (
+	"log"
+
 	"github.com/spf13/cobra"
 )
 
@@ -16,17 +18,12 @@ var stagingCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		logger.Println("staging called")
 		repoSlug, _ := cmd.Flags().GetString("repo-slug")
-		bbProject, _ := cmd.Flags().GetString("bitbucket-project")
-		sourceBranch, _ := cmd.Flags().GetString("source-branch")
-		product, _ := cmd.Flags().GetString("product")
-		services, _ := cmd.Flags().GetStringSlice("services")
-
-		myStagingConfig := PrConfig{
-			StagingRepoSlug: repoSlug,
-			BBProject:       bbProject,
-			SourceBranch:    sourceBranch,
-			Product:         product,
-			Services:        services,
+
+		myStagingConfig := newPrConfig(cmd, environmentStaging)
+		myStagingConfig.StagingRepoSlug = repoSlug
+		myStagingConfig, err := myStagingConfig.ResolveBranches()
+		if err != nil {
+			log.Fatal(err)
 		}
 
 		PrepRelease(myStagingConfig)
@@ -37,8 +34,5 @@ func init() {
 	rootCmd.AddCommand(stagingCmd)
 
 	stagingCmd.PersistentFlags().String("repo-slug", "", "The repository slug")
-	stagingCmd.PersistentFlags().String("bitbucket-project", "", "The repository bitbucket project")
-	stagingCmd.PersistentFlags().String("source-branch", "", "The branch to create")
-	stagingCmd.PersistentFlags().String("product", "", "The product which will also be the top level directory of the repo")
-	stagingCmd.PersistentFlags().StringSlice("services", []string{""}, "A list of the services that will be deployed to staging")
+	addReleaseFlags(stagingCmd)
 }
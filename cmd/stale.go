@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// What to do with other open release pull requests promoting the same services
+const (
+	stalePolicyIgnore  = "ignore"
+	stalePolicyDecline = "decline"
+	stalePolicyFail    = "fail"
+
+	defaultReleaseBranchPrefix = "release/"
+)
+
+// StalePullRequest is another open pull request into the target branch that
+// changes the app config of services this release promotes
+type StalePullRequest struct {
+	PullRequest
+	Services []string
+}
+
+func validStalePolicy(policy string) error {
+	switch policy {
+	case "", stalePolicyIgnore, stalePolicyDecline, stalePolicyFail:
+		return nil
+	}
+	return fmt.Errorf("unknown stale pull request policy %q, expected %s, %s or %s", policy, stalePolicyIgnore, stalePolicyDecline, stalePolicyFail)
+}
+
+// changedServices lists the services whose config.yaml under ArgocdDir the
+// branch changed since it was cut from the target branch. What moved on the
+// target since then isn't a change of the branch.
+func (s PrConfig) changedServices(r *git.Repository, branch string) ([]string, error) {
+	source, err := branchTree(r, branch)
+	if err != nil {
+		return nil, err
+	}
+	base, err := mergeBase(r, branch, s.TargetBranch)
+	if err != nil {
+		return nil, err
+	}
+	target, err := base.Tree()
+	if err != nil {
+		return nil, err
+	}
+	newConfigs, err := appConfigs(source, s.ArgocdDir())
+	if err != nil {
+		return nil, err
+	}
+	oldConfigs, err := appConfigs(target, s.ArgocdDir())
+	if err != nil {
+		return nil, err
+	}
+	var services []string
+	for service, config := range newConfigs {
+		if old, ok := oldConfigs[service]; !ok || old != config {
+			services = append(services, service)
+		}
+	}
+	for service := range oldConfigs {
+		if _, ok := newConfigs[service]; !ok {
+			services = append(services, service)
+		}
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// FindStalePullRequests looks for other open release pull requests into the
+// target branch touching the app configs of the services in this release,
+// using the branches of the gitops checkout. Pull requests from branches
+// outside ReleaseBranchPrefix, e.g. hand-written config fixes, are left alone.
+func (s PrConfig) FindStalePullRequests(r *git.Repository) error {
+	if s.Release == nil || s.StalePolicy == "" || s.StalePolicy == stalePolicyIgnore {
+		return nil
+	}
+	localRepoSlug := s.SetLocalRepoSlug()
+	prs, err := s.Provider.ListPullRequests(localRepoSlug, s.TargetBranch)
+	if err != nil {
+		return err
+	}
+	s.Release.Stale = nil
+	for _, pr := range prs {
+		if pr.SourceBranch == s.SourceBranch || !strings.HasPrefix(pr.SourceBranch, s.ReleaseBranchPrefix) {
+			continue
+		}
+		// The other release branch is fetched as deep as its merge base with
+		// the target
+		err := s.fetchBranches(r, localRepoSlug, 1, pr.SourceBranch)
+		if err == nil {
+			err = s.deepenUntilMergeBase(r, localRepoSlug, 1, pr.SourceBranch, []string{s.TargetBranch})
+		}
+		if err != nil {
+			logger.Printf("skipping pull request %d from %s: %s", pr.ID, pr.SourceBranch, err)
+			continue
+		}
+		changed, err := s.changedServices(r, pr.SourceBranch)
+		if err != nil {
+			logger.Printf("skipping pull request %d from %s: %s", pr.ID, pr.SourceBranch, err)
+			continue
+		}
+		var overlap []string
+		for _, service := range s.Release.Services {
+			if containsString(changed, service.Service) {
+				overlap = append(overlap, service.Service)
+			}
+		}
+		if len(overlap) > 0 {
+			logger.Printf("pull request %d from %s also promotes %s", pr.ID, pr.SourceBranch, strings.Join(overlap, ", "))
+			s.Release.Stale = append(s.Release.Stale, StalePullRequest{PullRequest: pr, Services: overlap})
+		}
+	}
+
+	if s.StalePolicy == stalePolicyFail && len(s.Release.Stale) > 0 {
+		var stale []string
+		for _, pr := range s.Release.Stale {
+			stale = append(stale, fmt.Sprintf("%d (%s: %s)", pr.ID, pr.SourceBranch, strings.Join(pr.Services, ", ")))
+		}
+		return fmt.Errorf("other open release pull requests promote the same services: %s", strings.Join(stale, ", "))
+	}
+	return nil
+}
+
+// DeclineStalePullRequests comments on every stale pull request with a link
+// to the release pull request that supersedes it and declines it
+func (s PrConfig) DeclineStalePullRequests(release PullRequest) error {
+	if s.Release == nil || s.StalePolicy != stalePolicyDecline {
+		return nil
+	}
+	localRepoSlug := s.SetLocalRepoSlug()
+	for _, stale := range s.Release.Stale {
+		text := fmt.Sprintf("Superseded by %s from %s, which promotes %s as well.", orNone(release.Url), s.SourceBranch, strings.Join(stale.Services, ", "))
+		err := s.Provider.CommentPullRequest(localRepoSlug, stale.PullRequest, text)
+		if err != nil {
+			return err
+		}
+		err = s.Provider.DeclinePullRequest(localRepoSlug, stale.PullRequest)
+		if err != nil {
+			return err
+		}
+		logger.Printf("declined stale pull request %d from %s", stale.ID, stale.SourceBranch)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestChangedServicesFromMergeBase checks a release branch cut before the
+// target moved only changes the services it touched itself
+func TestChangedServicesFromMergeBase(t *testing.T) {
+	r := testRepo(t, "pcoe", map[string]string{"api": "1.0.0", "web": "2.0.0"})
+	testBranch(t, r, "release/old", "main")
+	testCommit(t, r, "release/old", "pcoe", map[string]string{"api": "1.1.0"})
+	testCommit(t, r, "main", "pcoe", map[string]string{"web": "2.1.0", "worker": "0.1.0"})
+
+	s := PrConfig{Product: "pcoe", SourceBranch: "release/new", TargetBranch: "main"}
+	changed, err := s.changedServices(r, "release/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(changed, ",") != "api" {
+		t.Errorf("release/old changed %v, want only api", changed)
+	}
+}
+
+// stalePullRequests lists pull requests, any other provider call panics
+type stalePullRequests struct {
+	Provider
+	prs []PullRequest
+}
+
+func (p stalePullRequests) ListPullRequests(repoSlug string, targetBranch string) ([]PullRequest, error) {
+	return p.prs, nil
+}
+
+// TestFindStalePullRequestsSkipsOtherBranches checks pull requests from
+// outside the release branch prefix, e.g. config fixes, aren't looked at
+func TestFindStalePullRequestsSkipsOtherBranches(t *testing.T) {
+	r := testRepo(t, "pcoe", map[string]string{"api": "1.0.0"})
+	s := PrConfig{
+		Product:             "pcoe",
+		SourceBranch:        "release/new",
+		TargetBranch:        "main",
+		StalePolicy:         stalePolicyFail,
+		ReleaseBranchPrefix: defaultReleaseBranchPrefix,
+		Release:             &ReleaseReport{Services: []ServiceRelease{{Service: "api"}}},
+		Provider: stalePullRequests{prs: []PullRequest{
+			{ID: 1, SourceBranch: "release/new", TargetBranch: "main"},
+			{ID: 2, SourceBranch: "fix/api-memory-limit", TargetBranch: "main"},
+		}},
+	}
+	err := s.FindStalePullRequests(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Release.Stale) != 0 {
+		t.Errorf("stale pull requests are %+v, want none", s.Release.Stale)
+	}
+}
@@ -0,0 +1,195 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+const (
+	defaultPullRequestTemplateFile = ".auto-release-pr/pull-request.tmpl"
+	defaultTitleTemplate           = "Candidate release to {{ .Environment }}: {{ .SourceBranch }}"
+	defaultDescriptionTemplate     = `Candidate release to {{ .Environment }}: {{ .SourceBranch }}
+{{- if .Services }}
+
+{{ .VersionTable }}
+{{ end }}
+{{- if .Issues }}
+
+### Issues
+{{ range .Issues }}
+- {{ if .Url }}[{{ .Key }}]({{ .Url }}){{ else }}{{ .Key }}{{ end }} ({{ join .Services ", " }})
+{{- end }}
+{{ end }}
+{{- if .Changelog }}
+
+### Changelog
+
+{{ .Changelog }}
+{{ end }}`
+)
+
+// CIMetadata describes the Jenkins build running the tool, read from the
+// environment variables Jenkins sets
+type CIMetadata struct {
+	BuildUrl    string
+	JobName     string
+	BuildNumber string
+	BuildUser   string
+	GitCommit   string
+	GitBranch   string
+}
+
+func LoadCIMetadata() CIMetadata {
+	return CIMetadata{
+		BuildUrl:    os.Getenv("BUILD_URL"),
+		JobName:     os.Getenv("JOB_NAME"),
+		BuildNumber: os.Getenv("BUILD_NUMBER"),
+		BuildUser:   firstEnv("BUILD_USER_ID", "BUILD_USER", "CHANGE_AUTHOR"),
+		GitCommit:   os.Getenv("GIT_COMMIT"),
+		GitBranch:   firstEnv("GIT_BRANCH", "BRANCH_NAME"),
+	}
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// PullRequestData is what the title and description templates are executed with
+type PullRequestData struct {
+	Environment  string
+	Product      string
+	SourceBranch string
+	TargetBranch string
+	Services     []ServiceRelease
+	Updated      []ServiceRelease
+	VersionTable string
+	Changelogs   []ServiceChangelog
+	Changelog    string
+	Issues       []Issue
+	CI           CIMetadata
+}
+
+// The environments a release is promoted to
+const (
+	environmentStaging    = "staging"
+	environmentProduction = "production"
+)
+
+func (s PrConfig) Environment() string {
+	if s.IsStaging() {
+		return environmentStaging
+	}
+	return environmentProduction
+}
+
+func (s PrConfig) PullRequestData() PullRequestData {
+	data := PullRequestData{
+		Environment:  s.Environment(),
+		Product:      s.Product,
+		SourceBranch: s.SourceBranch,
+		TargetBranch: s.TargetBranch,
+		CI:           LoadCIMetadata(),
+	}
+	if s.Release != nil {
+		data.Services = s.Release.Services
+		data.Updated = s.Release.Updated
+		data.VersionTable = VersionTable(s.Release.Services)
+		data.Changelogs = s.Release.Changelogs
+		if s.Changelog {
+			data.Changelog = RenderChangelog(s.Release.Changelogs, 4)
+		}
+		data.Issues = s.Release.Issues
+	}
+	return data
+}
+
+// LoadPullRequestTemplate reads the template file from the gitops repository
+// checkout, if there is one. The first line is the title template, everything
+// after it the description template.
+func (s PrConfig) LoadPullRequestTemplate(fs billy.Filesystem) error {
+	if s.Release == nil {
+		return nil
+	}
+	name := s.PullRequestTemplateFile
+	if name == "" {
+		name = defaultPullRequestTemplateFile
+	}
+	f, err := fs.Open(name)
+	if os.IsNotExist(err) && s.PullRequestTemplateFile == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	logger.Println("using pull request template: ", name)
+	title, description := string(content), ""
+	if i := strings.Index(title, "\n"); i >= 0 {
+		title, description = title[:i], strings.TrimLeft(title[i+1:], "\n")
+	}
+	s.Release.TitleTemplate = title
+	s.Release.DescriptionTemplate = description
+	return nil
+}
+
+// renderTemplate picks the first non empty template, flag over file over default
+func (s PrConfig) renderTemplate(name string, templates ...string) (string, error) {
+	var text string
+	for _, t := range templates {
+		if t != "" {
+			text = t
+			break
+		}
+	}
+	return executeTemplate(name, text, s.PullRequestData())
+}
+
+// executeTemplate parses text and executes it with data
+func executeTemplate(name string, text string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Funcs(template.FuncMap{
+		"join":  strings.Join,
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+	}).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s template: %s", name, err)
+	}
+	var out bytes.Buffer
+	err = tmpl.Execute(&out, data)
+	if err != nil {
+		return "", fmt.Errorf("executing %s template: %s", name, err)
+	}
+	return out.String(), nil
+}
+
+func (s PrConfig) PullRequestTitle() (string, error) {
+	var fromFile string
+	if s.Release != nil {
+		fromFile = s.Release.TitleTemplate
+	}
+	title, err := s.renderTemplate("title", s.TitleTemplate, fromFile, defaultTitleTemplate)
+	return strings.TrimSpace(title), err
+}
+
+func (s PrConfig) PullRequestDescription() (string, error) {
+	var fromFile string
+	if s.Release != nil {
+		fromFile = s.Release.DescriptionTemplate
+	}
+	return s.renderTemplate("description", s.DescriptionTemplate, fromFile, defaultDescriptionTemplate)
+}
@@ -13,6 +13,7 @@ type File interface {
 
 type Config interface {
 	OpenPullRequest()
+	UpdatePullRequest()
 	CheckBranchExists() (bool, error)
 	CheckPullRequestExists() (bool, error)
 	CheckoutBranch(bool)
@@ -20,6 +21,7 @@ type Config interface {
 	UpdateManifests(*git.Repository, *git.Worktree, billy.Filesystem, billy.Filesystem, *sync.WaitGroup, string)
 	UpdateVersionFiles(*git.Repository, *git.Worktree, billy.Filesystem, billy.Filesystem)
 	CommitAndPush(*git.Repository, *git.Worktree)
+	ReportError() error
 }
 
 type PrConfig struct {
@@ -27,27 +29,66 @@ type PrConfig struct {
 	ProdRepoSlug    string
 	BBProject       string
 	SourceBranch    string
+	TargetBranch    string
+	StartPoint      string
 	Product         string
 	Services        []string
-}
+	Provider        Provider
+	CommentOnUpdate bool
+	Release         *ReleaseReport
 
-type CreateBranchPayload struct {
-	Message    string `json:"message"`
-	Name       string `json:"name"`
-	StartPoint string `json:"startPoint"`
-}
+	// Title and description templates given on the command line win over the
+	// template file found in the gitops repository
+	TitleTemplate           string
+	DescriptionTemplate     string
+	PullRequestTemplateFile string
+
+	// Changelog reads the application commits between the deployed and new
+	// image tag, AppSource overrides app.source and ChangelogFile is a file
+	// in the gitops repository to keep the changelog in
+	Changelog     bool
+	AppSource     string
+	ChangelogFile string
+
+	// IssueKeys lists the Jira issue keys of the application commits,
+	// optionally limited to IssueProjects. Prod releases can comment on and
+	// transition the issues of IssueProjects once the pull request is open,
+	// authenticated with JiraCredentials.
+	IssueKeys       bool
+	IssueProjects   []string
+	JiraUrl         string
+	JiraCredentials CredentialSource
+	JiraComment     bool
+	JiraTransition  string
+
+	// Reviewers are added to the pull request along with the owners of the
+	// released services listed in ReviewersFile
+	Reviewers     []string
+	ReviewersFile string
+
+	// StalePolicy says what to do with other open pull requests promoting the
+	// same services: ignore, decline or fail. Only pull requests from branches
+	// starting with ReleaseBranchPrefix are release pull requests.
+	StalePolicy         string
+	ReleaseBranchPrefix string
+
+	// Report posts a build status and report on the pushed release commit,
+	// linking to ReportUrl (default $BUILD_URL, else the commit)
+	Report    bool
+	ReportUrl string
+
+	// Signing signs the commits made for the release. Author and Committer
+	// override the git config user and CommitTemplate is the image tag update
+	// commit message template.
+	Signing        SigningOptions
+	Author         CommitIdentity
+	Committer      CommitIdentity
+	CommitTemplate string
 
-type PullRequestPayload struct {
-	FromRef struct {
-		ID   string `json:"id"`
-		Type string `json:"type"`
-	} `json:"fromRef"`
-	ToRef struct {
-		ID   string `json:"id"`
-		Type string `json:"type"`
-	} `json:"toRef"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	// FetchDepth is the depth the gitops repository is cloned at, deepened
+	// until the release branch shares history with the target branch and
+	// start point. 0 clones the full history.
+	FetchDepth int
 }
 
 type VersionFile struct {
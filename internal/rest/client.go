@@ -0,0 +1,125 @@
+// Package rest holds what the typed REST clients share: authentication, the
+// HTTP client and sending JSON requests.
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultHTTPClient is shared by every Client that doesn't bring its own
+var DefaultHTTPClient = &http.Client{Timeout: 60 * time.Second}
+
+// Authenticator sets the credentials on an outgoing request. go-git's http
+// BasicAuth and TokenAuth satisfy it, so the git and REST auth can be shared.
+type Authenticator interface {
+	SetAuth(r *http.Request)
+}
+
+// Client sends JSON requests to one service. Service names it in errors,
+// Header is added to every request and Messages reads the error messages out
+// of the body of a failed request.
+type Client struct {
+	Service    string
+	Auth       Authenticator
+	HTTPClient *http.Client
+	Header     http.Header
+	Messages   func(body []byte) []string
+}
+
+// Error is returned for every response outside the 2xx range. Messages are
+// the ones the service sent, Body is the raw body when it sent none.
+type Error struct {
+	Service    string
+	Method     string
+	Url        string
+	StatusCode int
+	Messages   []string
+	Body       string
+}
+
+func (e *Error) Error() string {
+	messages := e.Messages
+	if len(messages) == 0 && e.Body != "" {
+		messages = []string{e.Body}
+	}
+	return fmt.Sprintf("%s %s %s returned %d: %s", e.Service, e.Method, e.Url, e.StatusCode, strings.Join(messages, "; "))
+}
+
+// IsStatus reports whether err is, or wraps, an *Error with the status code
+func IsStatus(err error, statusCode int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.StatusCode == statusCode
+}
+
+// Do sends a request with a JSON body built from in (when not nil) and decodes
+// a successful response into out (when not nil). A response outside the 2xx
+// range is returned as an *Error.
+func (c Client) Do(method string, url string, in interface{}, out interface{}) error {
+	_, err := c.DoHeader(method, url, in, out)
+	return err
+}
+
+// DoHeader is Do returning the response header as well, for APIs that page
+// through a header
+func (c Client) DoHeader(method string, url string, in interface{}, out interface{}) (http.Header, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	if c.Auth != nil {
+		c.Auth.SetAuth(req)
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = DefaultHTTPClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &Error{Service: c.Service, Method: method, Url: url, StatusCode: resp.StatusCode}
+		if c.Messages != nil {
+			e.Messages = c.Messages(data)
+		}
+		if len(e.Messages) == 0 {
+			e.Body = string(data)
+		}
+		return nil, e
+	}
+	if out != nil && len(data) > 0 {
+		return resp.Header, json.Unmarshal(data, out)
+	}
+	return resp.Header, nil
+}
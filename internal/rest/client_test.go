@@ -0,0 +1,64 @@
+package rest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+func TestDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, `{"message": "unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte(`{"accept": "` + r.Header.Get("Accept") + `", "client": "` + r.Header.Get("X-Client") + `"}`))
+		case "/missing":
+			http.Error(w, `{"message": "no such thing"}`, http.StatusNotFound)
+		default:
+			http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+		}
+	}))
+	defer server.Close()
+	client := Client{
+		Service: "test",
+		Auth:    &githttp.TokenAuth{Token: "token"},
+		Header:  http.Header{"X-Client": {"auto-release-pr"}, "Accept": {"application/vnd.test+json"}},
+		Messages: func(body []byte) []string {
+			if strings.Contains(string(body), "no such thing") {
+				return []string{"no such thing"}
+			}
+			return nil
+		},
+	}
+
+	var out struct {
+		Accept string `json:"accept"`
+		Client string `json:"client"`
+	}
+	err := client.Do("GET", server.URL+"/ok", nil, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Accept != "application/vnd.test+json" || out.Client != "auto-release-pr" {
+		t.Errorf("request headers were %+v", out)
+	}
+
+	err = client.Do("GET", server.URL+"/missing", nil, nil)
+	if !IsStatus(err, http.StatusNotFound) || !strings.HasSuffix(err.Error(), "returned 404: no such thing") {
+		t.Errorf("a 404 returned %v", err)
+	}
+	if !IsStatus(fmt.Errorf("lookup: %w", err), http.StatusNotFound) {
+		t.Errorf("a wrapped 404 isn't one")
+	}
+	err = client.Do("POST", server.URL+"/slow", map[string]string{"a": "b"}, nil)
+	if !IsStatus(err, http.StatusGatewayTimeout) || !strings.Contains(err.Error(), "test POST") || !strings.Contains(err.Error(), "gateway timeout") {
+		t.Errorf("a 504 without messages returned %v", err)
+	}
+}
@@ -0,0 +1,56 @@
+// Package jira is a small client for the parts of the Jira REST API used to
+// annotate issues promoted by a release.
+package jira
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/internal/rest"
+)
+
+// DefaultHTTPClient is shared by every Client that doesn't bring its own
+var DefaultHTTPClient = rest.DefaultHTTPClient
+
+// Authenticator sets the credentials on an outgoing request. go-git's http
+// BasicAuth and TokenAuth satisfy it.
+type Authenticator = rest.Authenticator
+
+// Client talks to a single Jira instance. BaseUrl is the server root, e.g.
+// https://jira.example.com, the REST API and issue links are resolved below it.
+type Client struct {
+	BaseUrl    string
+	Auth       Authenticator
+	HTTPClient *http.Client
+}
+
+func NewClient(baseUrl string, auth Authenticator) *Client {
+	return &Client{BaseUrl: strings.TrimSuffix(baseUrl, "/"), Auth: auth, HTTPClient: DefaultHTTPClient}
+}
+
+// IssueUrl is the browser link of an issue
+func (c *Client) IssueUrl(key string) string {
+	return IssueUrl(c.BaseUrl, key)
+}
+
+// IssueUrl is the browser link of an issue on the Jira at baseUrl
+func IssueUrl(baseUrl string, key string) string {
+	return fmt.Sprintf("%s/browse/%s", strings.TrimSuffix(baseUrl, "/"), key)
+}
+
+func (c *Client) apiUrl(format string, args ...interface{}) string {
+	return c.BaseUrl + "/rest/api/2" + fmt.Sprintf(format, args...)
+}
+
+// Do sends a request with a JSON body built from in (when not nil) and decodes
+// a successful response into out (when not nil). A response outside the 2xx
+// range is returned as an *Error.
+func (c *Client) Do(method string, url string, in interface{}, out interface{}) error {
+	return rest.Client{
+		Service:    "jira",
+		Auth:       c.Auth,
+		HTTPClient: c.HTTPClient,
+		Messages:   errorMessages,
+	}.Do(method, url, in, out)
+}
@@ -0,0 +1,40 @@
+package jira
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/internal/rest"
+)
+
+// Error is returned for every response outside the 2xx range
+type Error = rest.Error
+
+// errorMessages reads the errorMessages and field errors of a failed
+// request's body
+func errorMessages(body []byte) []string {
+	var failure struct {
+		ErrorMessages []string          `json:"errorMessages"`
+		Errors        map[string]string `json:"errors"`
+	}
+	if json.Unmarshal(body, &failure) != nil {
+		return nil
+	}
+	messages := append([]string(nil), failure.ErrorMessages...)
+	var fields []string
+	for field := range failure.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		messages = append(messages, fmt.Sprintf("%s: %s", field, failure.Errors[field]))
+	}
+	return messages
+}
+
+// IsNotFound reports whether err is a Jira 404, e.g. an unknown issue key
+func IsNotFound(err error) bool {
+	return rest.IsStatus(err, http.StatusNotFound)
+}
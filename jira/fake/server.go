@@ -0,0 +1,118 @@
+// Package fake is an in-process stand-in for Jira holding issues in memory.
+// It implements the comment and transition endpoints the release flow uses.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"bitbucket.dentsplysirona.com/atopoc/auto-release-pr/jira"
+)
+
+// Workflow is the transitions every issue offers, keyed by their id
+var Workflow = []jira.Transition{
+	{ID: "11", Name: "Start progress", To: &jira.Status{Name: "In Progress"}},
+	{ID: "21", Name: "Release", To: &jira.Status{Name: "Released"}},
+}
+
+// Server serves the issues it was created with, anything else is a 404
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	nextID   int
+	comments map[string][]jira.Comment
+	statuses map[string]string
+}
+
+func NewServer(keys ...string) *Server {
+	s := &Server{
+		comments: map[string][]jira.Comment{},
+		statuses: map[string]string{},
+	}
+	for _, key := range keys {
+		s.statuses[key] = "Open"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rest/api/2/issue/", s.handleIssue)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Comments returns the comments left on an issue
+func (s *Server) Comments(key string) []jira.Comment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]jira.Comment(nil), s.comments[key]...)
+}
+
+// Status is the status an issue was last transitioned to
+func (s *Server) Status(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.statuses[key]
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]interface{}{
+		"errorMessages": []string{fmt.Sprintf(format, args...)},
+	})
+}
+
+// handleIssue routes /rest/api/2/issue/{key}/{comment,transitions}
+func (s *Server) handleIssue(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/rest/api/2/issue/"), "/")
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, "no such resource %s", r.URL.Path)
+		return
+	}
+	key, resource := parts[0], parts[1]
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.statuses[key]; !ok {
+		writeError(w, http.StatusNotFound, "Issue does not exist or you do not have permission to see it.")
+		return
+	}
+
+	switch {
+	case resource == "comment" && r.Method == "POST":
+		var comment jira.Comment
+		if json.NewDecoder(r.Body).Decode(&comment) != nil || comment.Body == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errors": map[string]string{"body": "Comment body can not be empty!"}})
+			return
+		}
+		s.nextID++
+		comment.ID = fmt.Sprintf("%d", s.nextID)
+		s.comments[key] = append(s.comments[key], comment)
+		writeJSON(w, http.StatusCreated, comment)
+	case resource == "transitions" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"transitions": Workflow})
+	case resource == "transitions" && r.Method == "POST":
+		var body struct {
+			Transition jira.Transition `json:"transition"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		for _, t := range Workflow {
+			if t.ID == body.Transition.ID {
+				s.statuses[key] = t.To.Name
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		writeJSON(w, http.StatusBadRequest, map[string]interface{}{"errorMessages": []string{fmt.Sprintf("Transition id '%s' is not valid for this issue.", body.Transition.ID)}})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "%s %s is not supported by the fake", r.Method, r.URL.Path)
+	}
+}
@@ -0,0 +1,68 @@
+package jira
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type Comment struct {
+	ID   string `json:"id,omitempty"`
+	Body string `json:"body"`
+}
+
+type Status struct {
+	Name string `json:"name"`
+}
+
+// Transition is a workflow step available on an issue
+type Transition struct {
+	ID   string  `json:"id"`
+	Name string  `json:"name"`
+	To   *Status `json:"to,omitempty"`
+}
+
+type transitions struct {
+	Transitions []Transition `json:"transitions"`
+}
+
+type doTransition struct {
+	Transition Transition `json:"transition"`
+}
+
+func (c *Client) AddComment(key string, body string) (*Comment, error) {
+	var created Comment
+	err := c.Do("POST", c.apiUrl("/issue/%s/comment", url.PathEscape(key)), Comment{Body: body}, &created)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// Transitions lists the transitions the user can apply to the issue now
+func (c *Client) Transitions(key string) ([]Transition, error) {
+	var out transitions
+	err := c.Do("GET", c.apiUrl("/issue/%s/transitions", url.PathEscape(key)), nil, &out)
+	return out.Transitions, err
+}
+
+func (c *Client) DoTransition(key string, id string) error {
+	return c.Do("POST", c.apiUrl("/issue/%s/transitions", url.PathEscape(key)), doTransition{Transition: Transition{ID: id}}, nil)
+}
+
+// TransitionTo applies the transition called name, or leading to the status
+// called name
+func (c *Client) TransitionTo(key string, name string) error {
+	available, err := c.Transitions(key)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, t := range available {
+		if strings.EqualFold(t.Name, name) || (t.To != nil && strings.EqualFold(t.To.Name, name)) {
+			return c.DoTransition(key, t.ID)
+		}
+		names = append(names, t.Name)
+	}
+	return fmt.Errorf("%s: no transition %q, available: %s", key, name, strings.Join(names, ", "))
+}